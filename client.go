@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// NewMMClient creates a Mattermost API client for the connection, authenticated with its token if there is one.  The
+// port is omitted from the URL if it's empty, and rate limited requests are handled by a RateLimitAwareClient.  Only
+// the form of the URL is checked, so an unreachable server will be reported by the first API call.
+func NewMMClient(conn mmConnection) (*model.Client4, error) {
+	host := conn.mmURL
+	if conn.mmPort != "" {
+		host += ":" + conn.mmPort
+	}
+	target := url.URL{Scheme: strings.ToLower(conn.mmScheme), Host: host}
+
+	if _, err := url.ParseRequestURI(target.String()); err != nil || conn.mmURL == "" {
+		return nil, errors.New("invalid Mattermost URL: " + target.String())
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, errors.New("unsupported HTTP scheme: " + target.Scheme)
+	}
+
+	DebugPrint("Full target for Mattermost: " + target.String())
+	mmClient := model.NewAPIv4Client(target.String())
+	mmClient.HTTPClient.Transport = &RateLimitAwareClient{Transport: mmClient.HTTPClient.Transport}
+	if conn.mmToken != "" {
+		mmClient.SetToken(conn.mmToken)
+	}
+
+	return mmClient, nil
+}
+
+// ValidateConnection checks that the Mattermost server can be reached, so that a wrong URL, port or scheme is
+// reported as such rather than as a failure of the first real API call.
+func ValidateConnection(ctx context.Context, mmClient model.Client4) error {
+	DebugPrint("Checking connection to " + mmClient.URL)
+
+	status, err := callWithRetry(ctx, "GetPing", mmClient.URL, func() (string, *model.Response, error) {
+		return mmClient.GetPing(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to reach Mattermost at %s - check the URL, port and scheme: %w", mmClient.URL, err)
+	}
+	if status != model.StatusOk {
+		return fmt.Errorf("the Mattermost server at %s reported status %q", mmClient.URL, status)
+	}
+
+	return nil
+}
+
+// HealthCheck confirms that the client's access token can be used, by retrieving the account it belongs to.  A
+// rejected token results in a TokenExpiredError, and a deactivated account in a DeactivatedUserError, so that they
+// can be told apart from a network failure.
+func HealthCheck(ctx context.Context, mmClient model.Client4) error {
+	DebugPrint("Checking the access token")
+
+	etag := ""
+
+	me, err := callWithRetry(ctx, "GetMe", mmClient.URL, func() (*model.User, *model.Response, error) {
+		return mmClient.GetMe(ctx, etag)
+	})
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+			return &TokenExpiredError{URL: mmClient.URL, Err: err}
+		}
+		return err
+	}
+	if me.Id == "" {
+		return fmt.Errorf("%s didn't return the account that the access token belongs to", mmClient.URL)
+	}
+	if me.DeleteAt != 0 {
+		return &DeactivatedUserError{Username: me.Username}
+	}
+
+	DebugPrint("The access token belongs to " + me.Username)
+	return nil
+}
+
+// GetServerVersion retrieves the version of the Mattermost server, e.g. "9.11.2".
+func GetServerVersion(ctx context.Context, mmClient model.Client4) (string, error) {
+	DebugPrint("Getting server version")
+
+	etag := ""
+
+	config, err := callWithRetry(ctx, "GetOldClientConfig", mmClient.URL, func() (map[string]string, *model.Response, error) {
+		return mmClient.GetOldClientConfig(ctx, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve server configuration: "+err.Error())
+		return "", err
+	}
+
+	version, found := config["Version"]
+	if !found || version == "" {
+		return "", errors.New("the server didn't report its version")
+	}
+	return version, nil
+}
+
+// compareVersions compares two dotted version numbers, returning a negative number if a is older than b, zero if
+// they match and a positive number if a is newer.  Missing components are treated as zero.
+func compareVersions(a string, b string) (int, error) {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aValue, bValue int
+		var err error
+		if i < len(aParts) {
+			if aValue, err = strconv.Atoi(aParts[i]); err != nil {
+				return 0, errors.New("invalid version: " + a)
+			}
+		}
+		if i < len(bParts) {
+			if bValue, err = strconv.Atoi(bParts[i]); err != nil {
+				return 0, errors.New("invalid version: " + b)
+			}
+		}
+		if aValue != bValue {
+			return aValue - bValue, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// CheckServerVersion warns if the server's version is outside the range that the tool has been tested against.
+func CheckServerVersion(ctx context.Context, mmClient model.Client4) error {
+	version, err := GetServerVersion(ctx, mmClient)
+	if err != nil {
+		return err
+	}
+	DebugPrint("Mattermost server version: " + version)
+
+	tooOld, err := compareVersions(version, minSupportedServerVersion)
+	if err != nil {
+		return err
+	}
+	tooNew, err := compareVersions(version, maxSupportedServerVersion)
+	if err != nil {
+		return err
+	}
+
+	if tooOld < 0 || tooNew > 0 {
+		LogMessage(warningLevel, fmt.Sprintf("Mattermost server version %s is outside the supported range of %s to %s - some counts may be inaccurate, or an updated version of this tool may be needed", version, minSupportedServerVersion, maxSupportedServerVersion))
+	} else {
+		LogMessage(infoLevel, "Mattermost server version "+version+" is supported")
+	}
+
+	return nil
+}
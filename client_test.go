@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "9.5.0", b: "9.5.0", want: 0},
+		{a: "9.5", b: "9.5.0", want: 0},
+		{a: "9.11.0", b: "9.5.0", want: 1},
+		{a: "8.1.2", b: "9.0.0", want: -1},
+		{a: "10.0.1", b: "10.0", want: 1},
+		{a: "9.x", b: "9.5.0", wantErr: true},
+		{a: "9.5.0", b: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" vs "+test.b, func(t *testing.T) {
+			got, err := compareVersions(test.a, test.b)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("compareVersions(%q, %q) error = %v, wantErr %v", test.a, test.b, err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			// Only the sign of the result is meaningful
+			if sign(got) != test.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want sign %d", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func sign(value int) int {
+	switch {
+	case value > 0:
+		return 1
+	case value < 0:
+		return -1
+	}
+	return 0
+}
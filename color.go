@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSI escape codes used to colour the text summary
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiCyan   = "\033[36m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+)
+
+// colorEnabled controls whether the text summary includes ANSI colour codes
+var colorEnabled bool = false
+
+// detectColor decides whether to colour the output.  The -color and -no-color flags take priority, followed by the
+// NO_COLOR convention (https://no-color.org) and a "dumb" TERM, and finally whether stdout is a terminal.
+func detectColor(forceColor bool, forceNoColor bool) bool {
+	if forceNoColor {
+		return false
+	}
+	if forceColor {
+		return true
+	}
+	if _, set := os.LookupEnv("NO_COLOR"); set {
+		return false
+	}
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+
+	return isTerminal(os.Stdout)
+}
+
+// colorize wraps the text in the given ANSI codes, if colour is enabled.
+func colorize(code string, text string) string {
+	if !colorEnabled {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+// padRight pads the text with spaces to the given width, measured before any colour codes are added, and colours it.
+func padRight(code string, text string, width int) string {
+	return colorize(code, text) + strings.Repeat(" ", max(width-len(text), 0))
+}
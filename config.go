@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// instanceConfig holds the connection details for a single Mattermost instance.
+type instanceConfig struct {
+	URL    string `json:"url"`
+	Port   string `json:"port"`
+	Scheme string `json:"scheme"`
+	Token  string `json:"token"`
+}
+
+// configFile is the structure of the optional JSON configuration file, which allows connection details for several
+// named Mattermost instances to be kept in one place.
+type configFile struct {
+	DefaultInstance string                    `json:"default_instance"`
+	Instances       map[string]instanceConfig `json:"instances"`
+}
+
+// LoadConfig reads and parses the configuration file at the given path.
+func LoadConfig(path string) (*configFile, error) {
+	DebugPrint("Loading configuration file: " + path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config configFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// SelectInstance returns the connection details for the named instance.  If no name is given, the configured default
+// instance is used, or the only instance if there's just one.
+func (config *configFile) SelectInstance(name string) (instanceConfig, error) {
+	if len(config.Instances) == 0 {
+		return instanceConfig{}, errors.New("the configuration file doesn't define any instances")
+	}
+	if name == "" {
+		name = config.DefaultInstance
+	}
+	if name == "" {
+		if len(config.Instances) != 1 {
+			return instanceConfig{}, errors.New("the configuration file defines several instances, so one must be selected with -instance")
+		}
+		for _, instance := range config.Instances {
+			return instance, nil
+		}
+	}
+
+	instance, found := config.Instances[name]
+	if !found {
+		return instanceConfig{}, errors.New("instance not found in configuration file: " + name)
+	}
+
+	return instance, nil
+}
+
+// valueOrDefault returns the value, or the fallback if the value is empty.
+func valueOrDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
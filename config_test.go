@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestSelectInstance(t *testing.T) {
+	production := instanceConfig{URL: "mm.example.com"}
+	staging := instanceConfig{URL: "staging.example.com"}
+
+	tests := []struct {
+		name     string
+		config   configFile
+		instance string
+		want     instanceConfig
+		wantErr  bool
+	}{
+		{
+			name:    "no instances",
+			config:  configFile{},
+			wantErr: true,
+		},
+		{
+			name:     "named instance",
+			config:   configFile{Instances: map[string]instanceConfig{"production": production, "staging": staging}},
+			instance: "staging",
+			want:     staging,
+		},
+		{
+			name:   "default instance",
+			config: configFile{DefaultInstance: "production", Instances: map[string]instanceConfig{"production": production, "staging": staging}},
+			want:   production,
+		},
+		{
+			name:   "only instance",
+			config: configFile{Instances: map[string]instanceConfig{"staging": staging}},
+			want:   staging,
+		},
+		{
+			name:    "several instances without a default",
+			config:  configFile{Instances: map[string]instanceConfig{"production": production, "staging": staging}},
+			wantErr: true,
+		},
+		{
+			name:     "unknown instance",
+			config:   configFile{Instances: map[string]instanceConfig{"production": production}},
+			instance: "test",
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.config.SelectInstance(test.instance)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("SelectInstance(%q) error = %v, wantErr %v", test.instance, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("SelectInstance(%q) = %v, want %v", test.instance, got, test.want)
+			}
+		})
+	}
+}
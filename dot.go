@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// dotQuote returns a quoted Graphviz DOT string, escaping any quotes or backslashes in the value.  Line breaks are
+// converted to the \n escape, which centres each line of a label.
+func dotQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// PrintDOT writes the user's team and channel memberships as a Graphviz DOT graph, with the user at the centre, each
+// team as a cluster, and each channel (when listed) as a leaf of its team.  For example:
+//
+//	mm-channel-count ... -format=dot -list-channels | dot -Tsvg > channels.svg
+func PrintDOT(report Report) error {
+	output := bufio.NewWriter(os.Stdout)
+
+	fmt.Fprintln(output, "digraph channels {")
+	fmt.Fprintln(output, "  rankdir=LR;")
+	fmt.Fprintf(output, "  user [label=%s, shape=doubleoctagon];\n", dotQuote(fmt.Sprintf("%s\n%d channels", report.Username, report.TotalChannelCount)))
+
+	for i, team := range report.Teams {
+		count := fmt.Sprintf("%d channels", team.ChannelCount)
+		if team.AccessDenied {
+			count = "access denied"
+		} else if team.Error != "" {
+			count = "error"
+		}
+
+		teamNode := fmt.Sprintf("team%d", i)
+		fmt.Fprintf(output, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(output, "    label=%s;\n", dotQuote(team.Name))
+		fmt.Fprintf(output, "    %s [label=%s, shape=box];\n", teamNode, dotQuote(fmt.Sprintf("%s\n%s", team.Name, count)))
+		for j, channel := range team.Channels {
+			fmt.Fprintf(output, "    %s_%d [label=%s];\n", teamNode, j, dotQuote(fmt.Sprintf("%s (%s)", channel.DisplayName, describeChannelType(channel.Type))))
+			fmt.Fprintf(output, "    %s -> %s_%d;\n", teamNode, teamNode, j)
+		}
+		fmt.Fprintln(output, "  }")
+		fmt.Fprintf(output, "  user -> %s;\n", teamNode)
+	}
+
+	fmt.Fprintf(output, "  dms [label=%s, shape=ellipse];\n", dotQuote(fmt.Sprintf("Direct messages: %d\nGroup messages: %d", report.DMChannelCount, report.GroupChannelCount)))
+	fmt.Fprintln(output, "  user -> dms;")
+	fmt.Fprintln(output, "}")
+
+	return output.Flush()
+}
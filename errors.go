@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// HTTPError is returned when Mattermost responds with an unexpected HTTP status code.
+type HTTPError struct {
+	StatusCode int
+	URL        string
+	Err        error
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("bad HTTP response %d from %s: %v", e.StatusCode, e.URL, e.Err)
+	}
+	return fmt.Sprintf("bad HTTP response %d from %s", e.StatusCode, e.URL)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// APIError identifies the Mattermost API function that failed, along with the underlying cause.  RequestID matches
+// the error to the debug messages logged for the request, where it is known.
+type APIError struct {
+	Function  string
+	Cause     error
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("function call to %s failed [%s]: %v", e.Function, e.RequestID, e.Cause)
+	}
+	return fmt.Sprintf("function call to %s failed: %v", e.Function, e.Cause)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Cause
+}
+
+// TokenExpiredError is returned when Mattermost rejects the access token, because it has expired, been revoked or was
+// never valid.
+type TokenExpiredError struct {
+	URL string
+	Err error
+}
+
+func (e *TokenExpiredError) Error() string {
+	return fmt.Sprintf("the access token was rejected by %s - it may have expired or been revoked: %v", e.URL, e.Err)
+}
+
+func (e *TokenExpiredError) Unwrap() error {
+	return e.Err
+}
+
+// DeactivatedUserError is returned when the access token belongs to a user, or bot, whose account is deactivated.
+type DeactivatedUserError struct {
+	Username string
+}
+
+func (e *DeactivatedUserError) Error() string {
+	return fmt.Sprintf("the access token belongs to %s, whose account is deactivated", e.Username)
+}
+
+// checkResponse converts the outcome of a Mattermost API call into an APIError if the call failed, either because of
+// an error or an unexpected HTTP status.  Where a response was received, the cause is an HTTPError carrying its status.
+func checkResponse(function string, url string, response *model.Response, err error) error {
+	if err != nil {
+		if response != nil && response.StatusCode != 0 {
+			err = &HTTPError{StatusCode: response.StatusCode, URL: url, Err: err}
+		}
+		return &APIError{Function: function, Cause: err}
+	}
+	if response.StatusCode != 200 {
+		return &APIError{Function: function, Cause: &HTTPError{StatusCode: response.StatusCode, URL: url}}
+	}
+	return nil
+}
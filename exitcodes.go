@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Exit codes used to report the outcome of a run.  Scripts rely on these values, so existing codes must not be
+// changed; new failure modes should be given a new code.
+const (
+	// ExitOK indicates that the run completed successfully
+	ExitOK = 0
+	// ExitBadArgs indicates invalid command line arguments or configuration
+	ExitBadArgs = 1
+	// ExitThresholdError indicates that the -threshold-error or -max-channel-count limit was exceeded
+	ExitThresholdError = 2
+	// ExitDeactivated indicates that a deactivated user was reported on with -deactivated
+	ExitDeactivated = 3
+	// ExitWarning indicates that a warning limit (-max-teams or -threshold-warn) was exceeded
+	ExitWarning = 4
+	// ExitUserNotFound indicates that the user, or the list of users, could not be retrieved
+	ExitUserNotFound = 10
+	// ExitTeamsError indicates that the teams could not be retrieved
+	ExitTeamsError = 11
+	// ExitChannelsError indicates that the channels could not be counted for too many teams
+	ExitChannelsError = 12
+	// ExitOutputError indicates that the output, or a saved report, could not be written
+	ExitOutputError = 13
+	// ExitDiffError indicates that the reports supplied to -diff could not be compared
+	ExitDiffError = 14
+	// ExitLoginFailed indicates that logging in with -username and -password failed
+	ExitLoginFailed = 15
+	// ExitWebhookError indicates that posting to a webhook failed
+	ExitWebhookError = 16
+	// ExitConnectionFailed indicates that the Mattermost server could not be reached
+	ExitConnectionFailed = 17
+	// ExitTokenRejected indicates that the access token is invalid, or belongs to a deactivated account
+	ExitTokenRejected = 18
+	// ExitCancelled indicates that processing was interrupted by a signal
+	ExitCancelled = 130
+)
+
+// exitCodeDescriptions explains each exit code, in the order listed in the help output.
+var exitCodeDescriptions = []struct {
+	code    int
+	meaning string
+}{
+	{ExitOK, "Success"},
+	{ExitBadArgs, "Invalid command line arguments or configuration"},
+	{ExitThresholdError, "The -threshold-error or -max-channel-count channel count was exceeded"},
+	{ExitDeactivated, "The user is deactivated (with -deactivated)"},
+	{ExitWarning, "The -max-teams or -threshold-warn limit was exceeded"},
+	{ExitUserNotFound, "The user (or list of users) could not be retrieved"},
+	{ExitTeamsError, "The teams could not be retrieved"},
+	{ExitChannelsError, "The channels could not be counted"},
+	{ExitOutputError, "The output or saved report could not be written"},
+	{ExitDiffError, "The reports supplied to -diff could not be compared"},
+	{ExitLoginFailed, "Logging in with -username and -password failed"},
+	{ExitWebhookError, "Posting to a webhook failed"},
+	{ExitConnectionFailed, "The Mattermost server could not be reached"},
+	{ExitTokenRejected, "The access token has expired or been revoked, or belongs to a deactivated account"},
+	{ExitCancelled, "Processing was interrupted"},
+}
+
+// printExitCodes writes a table of the exit codes and their meanings.
+func printExitCodes(output io.Writer) {
+	fmt.Fprintln(output, "Exit codes:")
+	for _, exitCode := range exitCodeDescriptions {
+		fmt.Fprintf(output, "  %-4d %s\n", exitCode.code, exitCode.meaning)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// saveTimestampFormat is used to name saved reports, so that they sort chronologically.
+const saveTimestampFormat = "2006-01-02T15:04:05"
+
+// SaveReport writes the report as JSON to a timestamped file within the given directory, creating the directory if
+// required.  The path to the new file is returned.
+func SaveReport(dir string, report Report) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", time.Now().Format(saveTimestampFormat), report.Username))
+	DebugPrint("Saving report: " + path)
+
+	// The trend is derived from the saved reports, so there's no need to save it again
+	report.Trend = nil
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadReport reads a report previously written with the JSON output format.
+func LoadReport(path string) (Report, error) {
+	DebugPrint("Loading report: " + path)
+
+	var report Report
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return report, nil
+}
+
+// teamKey identifies a team across runs, preferring the ID as display names can change.
+func teamKey(team Team) string {
+	if team.ID != "" {
+		return team.ID
+	}
+	return team.Name
+}
+
+// PrintDiff compares two reports and prints a diff-style summary of the changes in channel counts.  Teams that
+// only appear in one of the runs are flagged as added or removed.
+func PrintDiff(beforeName string, before Report, afterName string, after Report) {
+	fmt.Printf("--- %s\n", beforeName)
+	fmt.Printf("+++ %s\n\n", afterName)
+
+	afterTeams := make(map[string]Team)
+	for _, team := range after.Teams {
+		afterTeams[teamKey(team)] = team
+	}
+	beforeTeams := make(map[string]Team)
+	for _, team := range before.Teams {
+		beforeTeams[teamKey(team)] = team
+	}
+
+	for _, team := range before.Teams {
+		afterTeam, found := afterTeams[teamKey(team)]
+		if !found {
+			fmt.Printf("- %s : %d (team removed)\n", team.Name, team.ChannelCount)
+			continue
+		}
+		printCountChange(afterTeam.Name, team.ChannelCount, afterTeam.ChannelCount)
+	}
+	for _, team := range after.Teams {
+		if _, found := beforeTeams[teamKey(team)]; !found {
+			fmt.Printf("+ %s : %d (team added)\n", team.Name, team.ChannelCount)
+		}
+	}
+
+	fmt.Println()
+	printCountChange("Direct Message Channels", before.DMChannelCount, after.DMChannelCount)
+	printCountChange("Group Message Channels", before.GroupChannelCount, after.GroupChannelCount)
+	fmt.Println()
+}
+
+// printCountChange prints a single line of the diff, marking it with "~" if the count has changed.
+func printCountChange(name string, before int, after int) {
+	if before == after {
+		fmt.Printf("  %s : %d\n", name, after)
+		return
+	}
+	fmt.Printf("~ %s : %d -> %d (%+d)\n", name, before, after, after-before)
+}
+
+// RunDiff loads the two reports named on the command line and prints the differences between them.
+func RunDiff(args []string) error {
+	if len(args) != 2 {
+		return errors.New("the diff mode requires exactly two report files")
+	}
+
+	before, err := LoadReport(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := LoadReport(args[1])
+	if err != nil {
+		return err
+	}
+
+	if before.Username != after.Username {
+		LogMessage(warningLevel, fmt.Sprintf("Comparing reports for different users: %s and %s", before.Username, after.Username))
+	}
+
+	PrintDiff(args[0], before, args[1], after)
+	return nil
+}
+
+// TrendPoint records the user's total channel count at the time of a saved run.
+type TrendPoint struct {
+	Timestamp         time.Time
+	TotalChannelCount int
+}
+
+// savedReport identifies a report saved with SaveReport.
+type savedReport struct {
+	Path      string
+	Timestamp time.Time
+}
+
+// listSavedReports finds the reports saved for the user in the given directory, in chronological order.  Files that
+// aren't saved reports are ignored.
+func listSavedReports(dir string, username string) ([]savedReport, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := "-" + username + ".json"
+	var reports []savedReport
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || len(name) != len(saveTimestampFormat)+len(suffix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		timestamp, err := time.ParseInLocation(saveTimestampFormat, name[:len(saveTimestampFormat)], time.Local)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, savedReport{Path: filepath.Join(dir, name), Timestamp: timestamp})
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Timestamp.Before(reports[j].Timestamp)
+	})
+
+	return reports, nil
+}
+
+// LoadTrend reads every report saved for the user in the given directory, returning their total channel counts in
+// chronological order.
+func LoadTrend(dir string, username string) ([]TrendPoint, error) {
+	DebugPrint("Loading trend for " + username + " from " + dir)
+
+	reports, err := listSavedReports(dir, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var trend []TrendPoint
+	for _, saved := range reports {
+		report, err := LoadReport(saved.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Reports saved by older versions don't include the total, so it's always recalculated
+		trend = append(trend, TrendPoint{Timestamp: saved.Timestamp, TotalChannelCount: sumChannelCounts(report.Teams, report.DMChannelCount)})
+	}
+
+	return trend, nil
+}
+
+// LoadLatestReport reads the most recent report saved for the user in the given directory.  The boolean result is
+// false if no reports have been saved yet.
+func LoadLatestReport(dir string, username string) (Report, bool, error) {
+	reports, err := listSavedReports(dir, username)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(reports) == 0) {
+		return Report{}, false, nil
+	}
+	if err != nil {
+		return Report{}, false, err
+	}
+
+	report, err := LoadReport(reports[len(reports)-1].Path)
+	if err != nil {
+		return Report{}, false, err
+	}
+	return report, true, nil
+}
+
+// countsUnchanged reports whether two reports have the same teams, with the same channel counts.
+func countsUnchanged(before Report, after Report) bool {
+	if len(before.Teams) != len(after.Teams) || before.DMChannelCount != after.DMChannelCount ||
+		before.GroupChannelCount != after.GroupChannelCount {
+		return false
+	}
+
+	beforeCounts := make(map[string]int)
+	for _, team := range before.Teams {
+		beforeCounts[teamKey(team)] = team.ChannelCount
+	}
+	for _, team := range after.Teams {
+		count, found := beforeCounts[teamKey(team)]
+		if !found || count != team.ChannelCount {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestCountsUnchanged(t *testing.T) {
+	report := func(dms int, teams ...Team) Report {
+		return Report{User: User{Teams: teams}, DMChannelCount: dms}
+	}
+
+	tests := []struct {
+		name          string
+		before, after Report
+		want          bool
+	}{
+		{
+			name:   "identical",
+			before: report(2, Team{ID: "a", ChannelCount: 5}, Team{ID: "b", ChannelCount: 3}),
+			after:  report(2, Team{ID: "a", ChannelCount: 5}, Team{ID: "b", ChannelCount: 3}),
+			want:   true,
+		},
+		{
+			name:   "teams reordered",
+			before: report(2, Team{ID: "a", ChannelCount: 5}, Team{ID: "b", ChannelCount: 3}),
+			after:  report(2, Team{ID: "b", ChannelCount: 3}, Team{ID: "a", ChannelCount: 5}),
+			want:   true,
+		},
+		{
+			name:   "team count changed",
+			before: report(2, Team{ID: "a", ChannelCount: 5}),
+			after:  report(2, Team{ID: "a", ChannelCount: 6}),
+			want:   false,
+		},
+		{
+			name:   "team replaced",
+			before: report(2, Team{ID: "a", ChannelCount: 5}),
+			after:  report(2, Team{ID: "b", ChannelCount: 5}),
+			want:   false,
+		},
+		{
+			name:   "team added",
+			before: report(2, Team{ID: "a", ChannelCount: 5}),
+			after:  report(2, Team{ID: "a", ChannelCount: 5}, Team{ID: "b", ChannelCount: 1}),
+			want:   false,
+		},
+		{
+			name:   "direct messages changed",
+			before: report(2, Team{ID: "a", ChannelCount: 5}),
+			after:  report(3, Team{ID: "a", ChannelCount: 5}),
+			want:   false,
+		},
+		{
+			name:   "matched by name without an ID",
+			before: report(0, Team{Name: "Sales", ChannelCount: 4}),
+			after:  report(0, Team{Name: "Sales", ChannelCount: 4}),
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := countsUnchanged(test.before, test.after); got != test.want {
+				t.Errorf("countsUnchanged() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
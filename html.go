@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"time"
+)
+
+// htmlReportTemplate is a self-contained HTML page, with inline styles and script so that the report can be shared
+// as a single file.  Clicking a column heading sorts the teams table by that column.
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Channel count for {{.Report.Username}} - {{.Generated}}</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0 auto; max-width: 960px; padding: 1em; color: #222; }
+  h1 { font-size: 1.5em; }
+  dl { display: grid; grid-template-columns: max-content auto; gap: 0.25em 1em; }
+  dt { font-weight: bold; }
+  dd { margin: 0; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { border-bottom: 1px solid #ddd; padding: 0.5em; text-align: left; }
+  th { background: #f4f4f4; cursor: pointer; user-select: none; }
+  td.count, th.count { text-align: right; }
+  tfoot td { font-weight: bold; }
+  footer { color: #777; font-size: 0.85em; margin-top: 2em; }
+  @media (max-width: 600px) { body { padding: 0.5em; } th, td { padding: 0.25em; } }
+</style>
+</head>
+<body>
+<h1>Channel count for {{.Report.Username}}</h1>
+<dl>
+  <dt>Lookup</dt><dd>{{.Report.LookupField}}</dd>
+  <dt>Username</dt><dd>{{.Report.Username}}{{if .Report.Deactivated}} (deactivated){{end}}</dd>
+  <dt>Email</dt><dd>{{.Report.Email}}</dd>
+  <dt>Name</dt><dd>{{.Report.FirstName}} {{.Report.LastName}}</dd>
+  <dt>Nickname</dt><dd>{{.Report.NickName}}</dd>
+</dl>
+<table id="teams">
+<thead>
+  <tr><th>Team</th><th>Team ID</th><th class="count">Channels</th></tr>
+</thead>
+<tbody>
+{{- range .Report.Teams}}
+  <tr><td>{{.Name}}</td><td>{{.ID}}</td><td class="count">{{if .AccessDenied}}access denied{{else if .Error}}error{{else}}{{.ChannelCount}}{{end}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+  <tr><td colspan="2">Direct Message Channels</td><td class="count">{{.Report.DMChannelCount}}</td></tr>
+  <tr><td colspan="2">Group Message Channels</td><td class="count">{{.Report.GroupChannelCount}}</td></tr>
+  <tr><td colspan="2">Total channel count</td><td class="count">{{.Report.TotalChannelCount}}</td></tr>
+</tfoot>
+</table>
+<footer>Generated by mm-channel-count {{.Version}} at {{.Generated}}</footer>
+<script>
+document.querySelectorAll("#teams thead th").forEach(function (heading, column) {
+  heading.addEventListener("click", function () {
+    var body = document.querySelector("#teams tbody");
+    var ascending = heading.dataset.order !== "asc";
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[column].textContent, y = b.cells[column].textContent;
+      var order = isNaN(x) || isNaN(y) ? x.localeCompare(y) : x - y;
+      return ascending ? order : -order;
+    });
+    rows.forEach(function (row) { body.appendChild(row); });
+    heading.dataset.order = ascending ? "asc" : "desc";
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// PrintHTML writes the report as a self-contained HTML page.
+func PrintHTML(report Report) error {
+	return htmlReportTemplate.Execute(os.Stdout, struct {
+		Report    Report
+		Version   string
+		Generated string
+	}{
+		Report:    report,
+		Version:   Version,
+		Generated: time.Now().Format(time.DateTime),
+	})
+}
@@ -6,7 +6,17 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 )
@@ -15,6 +25,12 @@ var Version = "development" // Default value - overwritten during bild process
 
 var debugMode bool = false
 
+// logToStderr sends all log messages to stderr, keeping stdout clean for machine-readable output formats
+var logToStderr bool = false
+
+// logMutex serialises log output, as the log destination is switched between stdout and stderr per message.
+var logMutex sync.Mutex
+
 // LogLevel is used to refer to the type of message that will be written using the logging code.
 type LogLevel string
 
@@ -39,10 +55,300 @@ const (
 	maxErrors     = 3
 )
 
+// The range of Mattermost server versions that this tool has been tested against, as checked by -version-check
+const (
+	minSupportedServerVersion = "8.0.0"
+	maxSupportedServerVersion = "10.99.99"
+)
+
+// Supported output formats
+const (
+	formatText     = "text"
+	formatBarChart = "bar-chart"
+	formatCSV      = "csv"
+	formatTSV      = "tsv"
+	formatJSON     = "json"
+	formatXML      = "xml"
+	formatNDJSON   = "ndjson"
+	formatHTML     = "html"
+	formatDOT      = "dot"
+)
+
+// errAccessDenied is returned when Mattermost refuses a request, which is expected for guest accounts.
+var errAccessDenied = errors.New("access denied")
+
+// isForbidden reports whether an API call failed because Mattermost refused access with HTTP 403.
+func isForbidden(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusForbidden
+}
+
+// checkAccessDenied converts a refusal of any of a team's requests into errAccessDenied when access denied responses
+// are expected, as guest accounts have restricted API access, so a refusal isn't necessarily a failure.
+func checkAccessDenied(err error, guestSafe bool, teamID string) error {
+	if guestSafe && isForbidden(err) {
+		LogMessage(warningLevel, "Access denied when retrieving channels for team ID: "+teamID)
+		return errAccessDenied
+	}
+	return err
+}
+
+// logTeamRequestError logs a failure of one of the requests made while counting a team's channels.  A refusal isn't
+// logged when access denied responses are expected, as checkAccessDenied reports the team as access denied instead.
+func logTeamRequestError(message string, err error, guestSafe bool) {
+	if guestSafe && isForbidden(err) {
+		return
+	}
+	LogMessage(errorLevel, message+": "+err.Error())
+}
+
+// Every team automatically includes the Town Square (model.DefaultChannelName) and Off-Topic system channels.
+const (
+	offTopicChannelName    = "off-topic"
+	expectedSystemChannels = 2
+)
+
 type Team struct {
 	Name         string
 	ID           string
 	ChannelCount int
+	UnreadCount  int
+	MemberStats  MemberStats
+	Channels     []ChannelInfo `json:",omitempty" xml:"Channels>Channel,omitempty"`
+	AccessDenied bool          `json:",omitempty"`
+	// Error describes why the team couldn't be counted, in which case ChannelCount is -1
+	Error string `json:",omitempty"`
+	// ChannelsByType breaks ChannelCount down by the one-character channel type (O or P), including types with no
+	// channels.  It's left out of the XML output, which can't represent a map.
+	ChannelsByType map[string]int `xml:"-"`
+
+	SystemChannelsExcluded int
+	ArchivedChannelCount   int
+	SharedChannelCount     int `json:",omitempty"`
+	MutedChannelCount      int `json:",omitempty"`
+	FavouriteChannelCount  int `json:",omitempty"`
+	PinnedPostCount        int `json:",omitempty"`
+
+	// Role is the user's role within the team (admin, member or guest), when requested
+	Role string `json:",omitempty"`
+	// TeamMemberCount is the total number of members of the team, when requested
+	TeamMemberCount int `json:",omitempty"`
+	// TeamChannelCount is the total number of public and private channels in the team, when requested
+	TeamChannelCount int `json:",omitempty"`
+	// SidebarCategories lists the user's sidebar categories for the team, in display order, when requested
+	SidebarCategories []SidebarCategoryCount `json:",omitempty" xml:"SidebarCategories>Category,omitempty"`
+}
+
+// SidebarCategoryCount records how many channels the user has placed in one of their sidebar categories.
+type SidebarCategoryCount struct {
+	Name         string
+	ChannelCount int
+}
+
+// ChannelInfo describes a single channel that a user is a member of.  MemberCount is only populated when member
+// statistics have been requested, as it requires an additional API call per channel.  There's no field for when the
+// user joined the channel: the channel membership returned by the API (model.ChannelMember) doesn't record a join
+// time, and its LastUpdateAt changes whenever the membership is updated, so it can't be used as a substitute.
+type ChannelInfo struct {
+	Name        string
+	DisplayName string
+	Type        string
+	MemberCount int `json:",omitempty"`
+	Purpose     string
+	Header      string
+	CreateAt    time.Time
+	LastPostAt  time.Time
+}
+
+// newChannelInfo extracts the details we report on from a Mattermost channel.
+func newChannelInfo(channel *model.Channel) ChannelInfo {
+	return ChannelInfo{
+		Name:        channel.Name,
+		DisplayName: channel.DisplayName,
+		Type:        string(channel.Type),
+		Purpose:     channel.Purpose,
+		Header:      channel.Header,
+		CreateAt:    millisToTime(channel.CreateAt),
+		LastPostAt:  millisToTime(channel.LastPostAt),
+	}
+}
+
+// millisToTime converts a Mattermost timestamp, in milliseconds since the epoch, to a time.  A zero timestamp, meaning
+// that the event hasn't happened, results in the zero time.
+func millisToTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// maxVerboseFieldLength limits the length of channel purposes and headers in the text output
+const maxVerboseFieldLength = 80
+
+// truncate shortens a string to at most maxLength characters, marking any truncation with an ellipsis.
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	if len(runes) <= maxLength {
+		return value
+	}
+	return string(runes[:maxLength-3]) + "..."
+}
+
+// formatChannelsByType describes a team's channel count by type, e.g. "Public: 5, Private: 2", for the text output.
+// Only the channel types being counted are included, and an empty string is returned for a team that wasn't counted.
+func formatChannelsByType(countsByType map[string]int, options summaryOptions) string {
+	if countsByType == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, channelType := range []model.ChannelType{model.ChannelTypeOpen, model.ChannelTypePrivate} {
+		if !channelTypeSelected(channelType, options.channelTypes) || options.excludedChannelTypes[channelType] {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d", describeChannelType(string(channelType)), countsByType[string(channelType)]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatLastPostAge describes how long ago a channel was last posted in, for the text output.
+func formatLastPostAge(lastPostAt time.Time) string {
+	if lastPostAt.IsZero() {
+		return "never"
+	}
+	days := int(time.Since(lastPostAt).Hours() / 24)
+	if days == 1 {
+		return "1 day ago"
+	}
+	return fmt.Sprintf("%d days ago", days)
+}
+
+// MemberStats summarises the number of members across the channels a user belongs to within a team.
+type MemberStats struct {
+	Average float64
+	Minimum int
+	Maximum int
+}
+
+// countOptions controls which channels are counted by GetChannelCountForTeam, and what additional
+// information is gathered for each team.
+type countOptions struct {
+	channelTypes map[model.ChannelType]bool
+	countUnread  bool
+	memberStats  bool
+	since        time.Time
+
+	// excludedChannelTypes holds the channel types that aren't counted, as the inverse of channelTypes
+	excludedChannelTypes map[model.ChannelType]bool
+
+	excludeSystemChannels bool
+	onlySystemChannels    bool
+	role                  string
+	listChannels          bool
+	channelFilter         *regexp.Regexp
+	noDMs                 bool
+	guestSafe             bool
+	includeArchived       bool
+	purposeFilter         string
+	teamRole              bool
+	teamMemberCount       bool
+	teamSummary           bool
+	remoteOnly            bool
+	countMuted            bool
+	countFavourites       bool
+	sidebarCategories     bool
+	staleBefore           time.Time
+	countPinned           bool
+	gracefulDegradation   bool
+	rateLimitSleep        time.Duration
+
+	// favouriteChannels holds the IDs of the user's favourite channels, which CountChannelsForTeams retrieves when
+	// favourites are being counted
+	favouriteChannels map[string]bool
+
+	// teamCounted, if set, is called by CountChannelsForTeams as soon as each team has been counted
+	teamCounted func(user User, team Team)
+}
+
+// filtered reports whether any of the options restrict which channels are counted.
+func (options countOptions) filtered() bool {
+	return len(options.channelTypes) > 0 || len(options.excludedChannelTypes) > 0 || !options.since.IsZero() || options.excludeSystemChannels ||
+		options.onlySystemChannels || options.role != "" || options.channelFilter != nil ||
+		options.purposeFilter != "" || options.remoteOnly || !options.staleBefore.IsZero()
+}
+
+// channelCounts holds the results of counting the channels for a single team.
+type channelCounts struct {
+	Channels    int
+	ByType      map[string]int
+	Unread      int
+	MemberStats MemberStats
+	ChannelList []ChannelInfo
+	Archived    int
+	Shared      int
+	Muted       int
+	Favourites  int
+	PinnedPosts int
+
+	SystemChannelsExcluded int
+}
+
+// teamCountResult carries the outcome of counting the channels for a single team back from a worker.
+type teamCountResult struct {
+	index        int
+	counts       channelCounts
+	role         string
+	memberCount  int
+	channelCount int
+	categories   []SidebarCategoryCount
+	err          error
+}
+
+// summaryOptions controls the optional content displayed by PrintSummary and the other output formatters.
+type summaryOptions struct {
+	showPercent     bool
+	showUnread      bool
+	showMemberStats bool
+	showStats       bool
+	listChannels    bool
+	verbose         bool
+	nameWidth       int
+	noHeader        bool
+	hideDMs         bool
+
+	showSystemChannelsExcluded bool
+	showArchived               bool
+	showTeamRole               bool
+	showTeamMemberCount        bool
+	showTeamSummary            bool
+	showShared                 bool
+	showMuted                  bool
+	showFavourites             bool
+	showSidebarCategories      bool
+	showPinned                 bool
+
+	// inactiveDMDays is the period used to count inactive direct messages, which are shown when set
+	inactiveDMDays int
+
+	// instanceTeamCount is the total number of teams on the instance, shown alongside the user's team count when set.
+	// memberTeamCount is the number of teams the user is a member of, before any teams were excluded or suppressed.
+	instanceTeamCount int
+	memberTeamCount   int
+
+	// suppressedTeams is the number of teams left out of the output by -suppress-zero-teams
+	suppressedTeams int
+
+	// dmChannelsFailed is set when the direct and group messages couldn't be counted
+	dmChannelsFailed bool
+
+	// channelTypes and excludedChannelTypes limit the channel types shown in each team's breakdown to those counted
+	channelTypes         map[model.ChannelType]bool
+	excludedChannelTypes map[model.ChannelType]bool
+
+	// channelCountDelta is the change in the total channel count since the last saved run, if there was one, and is
+	// shown when showChannelCountDelta is set
+	showChannelCountDelta bool
+	channelCountDelta     *int
 }
 
 type User struct {
@@ -52,14 +358,40 @@ type User struct {
 	FirstName string
 	LastName  string
 	NickName  string
-	Teams     []Team
+	Teams     []Team `json:",omitempty" xml:"Teams>Team,omitempty"`
+
+	// LookupField records how the user was resolved (e.g. by username or email)
+	LookupField string
+	Deactivated bool `json:",omitempty"`
+
+	// TotalChannelCount is the channel count across all teams, plus direct message channels, once counting is complete
+	TotalChannelCount int
+	// DMPartners lists the usernames of the user's direct message partners, when requested
+	DMPartners []string `json:",omitempty"`
+	// InactiveDMCount is the number of direct and group message channels without recent posts, when requested
+	InactiveDMCount int `json:",omitempty"`
+}
+
+// stringListFlag is a flag.Value that collects every occurrence of a repeatable command line flag.
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 // Logging functions
 
 // LogMessage logs a formatted message to stdout or stderr
 func LogMessage(level LogLevel, message string) {
-	if level == errorLevel {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if level == errorLevel || logToStderr {
 		log.SetOutput(os.Stderr)
 	} else {
 		log.SetOutput(os.Stdout)
@@ -85,279 +417,2086 @@ func getEnvWithDefault(key string, defaultValue interface{}) interface{} {
 	return value
 }
 
-func GetUserIDFromUsername(mmClient model.Client4, username string) (*User, error) {
+// defaultPortForScheme returns the port to use when none has been given.  An explicitly chosen scheme implies its
+// standard port, otherwise the default Mattermost port is used.
+func defaultPortForScheme(scheme string) string {
+	switch strings.ToLower(scheme) {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return defaultPort
+}
+
+// stripURLScheme removes an HTTP or HTTPS scheme from the start of a URL, ignoring case.  The URL is returned without
+// the scheme, along with the scheme in lower case, which is empty if the URL didn't include one.
+func stripURLScheme(rawURL string) (string, string) {
+	for _, scheme := range []string{"http", "https"} {
+		prefix := scheme + "://"
+		if len(rawURL) >= len(prefix) && strings.EqualFold(rawURL[:len(prefix)], prefix) {
+			return rawURL[len(prefix):], scheme
+		}
+	}
+	return rawURL, ""
+}
+
+// newUserFromModel converts a Mattermost user into our own User struct, recording how it was looked up.
+func newUserFromModel(user *model.User, lookupField string) *User {
+	return &User{
+		ID:          user.Id,
+		Username:    user.Username,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		NickName:    user.Nickname,
+		LookupField: lookupField,
+		Deactivated: user.DeleteAt != 0,
+	}
+}
+
+func GetUserIDFromUsername(ctx context.Context, mmClient model.Client4, username string) (*User, error) {
 	DebugPrint("Getting user ID for user: " + username)
 
-	ctx := context.Background()
 	etag := ""
 
-	user, response, err := mmClient.GetUserByUsername(ctx, username, etag)
-
+	user, err := callWithRetry(ctx, "GetUserByUsername", mmClient.URL, func() (*model.User, *model.Response, error) {
+		return mmClient.GetUserByUsername(ctx, username, etag)
+	})
 	if err != nil {
 		LogMessage(errorLevel, "Failed to retrieve user: "+err.Error())
 		return nil, err
 	}
-	if response.StatusCode != 200 {
-		LogMessage(errorLevel, "Function call to GetUserByUsername returned bad HTTP response")
-		return nil, errors.New("bad HTTP response")
-	}
 
-	mmUser := &User{
-		ID:        user.Id,
-		Username:  username,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		NickName:  user.Nickname,
+	return newUserFromModel(user, "username"), nil
+}
+
+// GetUserIDFromEmail retrieves the ID (and other information) of a user based on their email address.
+func GetUserIDFromEmail(ctx context.Context, mmClient model.Client4, email string) (*User, error) {
+	DebugPrint("Getting user ID for email: " + email)
+
+	etag := ""
+
+	user, err := callWithRetry(ctx, "GetUserByEmail", mmClient.URL, func() (*model.User, *model.Response, error) {
+		return mmClient.GetUserByEmail(ctx, email, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve user: "+err.Error())
+		return nil, err
 	}
 
-	return mmUser, nil
+	return newUserFromModel(user, "email"), nil
 }
 
-func GetChannelCountForTeam(mmClient model.Client4, teamID string, userID string, countDMs bool) (int, int, int, error) {
-	DebugPrint("Getting channel count for team ID: " + teamID)
+// GetUserFromID retrieves the information for a user whose Mattermost ID is already known.
+func GetUserFromID(ctx context.Context, mmClient model.Client4, userID string) (*User, error) {
+	DebugPrint("Getting user for ID: " + userID)
 
-	channelCount := 0
-	dmChannelCount := 0
-	groupCount := 0
-	ctx := context.Background()
 	etag := ""
 
-	channels, response, err := mmClient.GetChannelsForTeamForUser(ctx, teamID, userID, false, etag)
-
+	user, err := callWithRetry(ctx, "GetUser", mmClient.URL, func() (*model.User, *model.Response, error) {
+		return mmClient.GetUser(ctx, userID, etag)
+	})
 	if err != nil {
-		LogMessage(errorLevel, "Failed to retrieve channels: "+err.Error())
-		return -1, -1, -1, err
+		LogMessage(errorLevel, "Failed to retrieve user: "+err.Error())
+		return nil, err
 	}
-	if response.StatusCode != 200 {
-		LogMessage(errorLevel, "Function call to GetChannelsForTeamForUser returned bad HTTP response")
-		return -1, -1, -1, errors.New("bad HTTP response")
+
+	return newUserFromModel(user, "user ID"), nil
+}
+
+// parseChannelTypes converts a comma-separated list of Mattermost channel type characters (O, P, D, G) into a set.
+// An empty list results in an empty set, which is treated as "all channel types".
+func parseChannelTypes(value string) (map[model.ChannelType]bool, error) {
+	channelTypes := make(map[model.ChannelType]bool)
+	if value == "" {
+		return channelTypes, nil
 	}
 
-	for _, channel := range channels {
-		if channel.Type == "D" {
-			if countDMs {
-				dmChannelCount++
-			}
-		} else if channel.Type == "G" {
-			if countDMs {
-				groupCount++
-			}
-		} else {
-			channelCount++
+	for _, item := range strings.Split(value, ",") {
+		channelType := model.ChannelType(strings.ToUpper(strings.TrimSpace(item)))
+		switch channelType {
+		case model.ChannelTypeOpen, model.ChannelTypePrivate, model.ChannelTypeDirect, model.ChannelTypeGroup:
+			channelTypes[channelType] = true
+		default:
+			return nil, errors.New("invalid channel type: " + item)
 		}
 	}
 
-	return channelCount, dmChannelCount, groupCount, nil
+	return channelTypes, nil
 }
 
-func GetTeamsForUser(mmClient model.Client4, userID string) ([]Team, error) {
+// parseSince converts a date supplied on the command line, either in RFC 3339 or YYYY-MM-DD format, into a time.
+// An empty value results in the zero time, which disables date filtering.
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
 
-	DebugPrint("Getting teams for user ID: " + userID)
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, nil
+	}
+
+	since, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339 or YYYY-MM-DD format: " + value)
+	}
+
+	return since, nil
+}
+
+// isSystemChannel reports whether a channel is one of those automatically created in every team.
+func isSystemChannel(channel *model.Channel) bool {
+	return channel.Name == model.DefaultChannelName || channel.Name == offTopicChannelName
+}
+
+// channelMemberHasRole reports whether the user's channel membership grants the requested role (admin, member or guest).
+// Channel admins also hold the user role, so "member" only matches those who aren't also admins.
+func channelMemberHasRole(member model.ChannelMember, role string) bool {
+	roles := strings.Fields(member.Roles)
+	switch role {
+	case "admin":
+		return slices.Contains(roles, model.ChannelAdminRoleId)
+	case "member":
+		return slices.Contains(roles, model.ChannelUserRoleId) && !slices.Contains(roles, model.ChannelAdminRoleId)
+	case "guest":
+		return slices.Contains(roles, model.ChannelGuestRoleId)
+	}
+	return false
+}
+
+// describeChannelType returns a human-readable name for a Mattermost channel type.
+func describeChannelType(channelType string) string {
+	switch model.ChannelType(channelType) {
+	case model.ChannelTypeOpen:
+		return "Public"
+	case model.ChannelTypePrivate:
+		return "Private"
+	case model.ChannelTypeDirect:
+		return "Direct"
+	case model.ChannelTypeGroup:
+		return "Group"
+	}
+	return channelType
+}
+
+// channelTypeSelected reports whether a channel type should be counted, given the set of requested types.
+func channelTypeSelected(channelType model.ChannelType, channelTypes map[model.ChannelType]bool) bool {
+	if len(channelTypes) == 0 {
+		return true
+	}
+	return channelTypes[channelType]
+}
+
+// filterChannelsByType returns the channels whose types are in the set of requested types (or all types, if the set
+// is empty) and aren't in the set of excluded types.
+func filterChannelsByType(channels []*model.Channel, channelTypes map[model.ChannelType]bool, excludedTypes map[model.ChannelType]bool) []*model.Channel {
+	var filtered []*model.Channel
+	for _, channel := range channels {
+		if channelTypeSelected(channel.Type, channelTypes) && !excludedTypes[channel.Type] {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// GetChannelMembersForTeam retrieves the user's channel memberships for a team, keyed by channel ID.
+func GetChannelMembersForTeam(ctx context.Context, mmClient model.Client4, teamID string, userID string) (map[string]model.ChannelMember, error) {
+	DebugPrint("Getting channel memberships for team ID: " + teamID)
 
-	ctx := context.Background()
 	etag := ""
 
-	teams, response, err := mmClient.GetTeamsForUser(ctx, userID, etag)
+	members, err := callWithRetry(ctx, "GetChannelMembersForUser", mmClient.URL, func() (model.ChannelMembers, *model.Response, error) {
+		return mmClient.GetChannelMembersForUser(ctx, userID, teamID, etag)
+	})
 	if err != nil {
-		LogMessage(errorLevel, "Failed to retrieve teams: "+err.Error())
 		return nil, err
 	}
-	if response.StatusCode != 200 {
-		LogMessage(errorLevel, "Function call to GetTeamsForUser returned bad HTTP response")
-		return nil, errors.New("bad HTTP response")
+
+	membersByChannel := make(map[string]model.ChannelMember)
+	for _, member := range members {
+		membersByChannel[member.ChannelId] = member
 	}
 
-	var teamsList []Team
+	return membersByChannel, nil
+}
 
-	for _, mmTeam := range teams {
-		team := Team{
-			Name: mmTeam.DisplayName,
-			ID:   mmTeam.Id,
+// GetFavouriteChannelIDs retrieves the IDs of the channels that the user has marked as favourites.
+func GetFavouriteChannelIDs(ctx context.Context, mmClient model.Client4, userID string) (map[string]bool, error) {
+	DebugPrint("Getting favourite channels for user ID: " + userID)
+
+	favourites := make(map[string]bool)
+
+	preferences, err := callWithRetry(ctx, "GetPreferencesByCategory", mmClient.URL, func() (model.Preferences, *model.Response, error) {
+		return mmClient.GetPreferencesByCategory(ctx, userID, model.PreferenceCategoryFavoriteChannel)
+	})
+	if err != nil {
+		// Mattermost responds with a 404 if the user has never marked a channel as a favourite
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return favourites, nil
 		}
+		LogMessage(errorLevel, "Failed to retrieve favourite channels: "+err.Error())
+		return nil, err
+	}
 
-		teamsList = append(teamsList, team)
+	for _, preference := range preferences {
+		if preference.Value == "true" {
+			favourites[preference.Name] = true
+		}
 	}
 
-	return teamsList, nil
+	return favourites, nil
 }
 
-func PrintSummary(user User, totalDMChannels int, totalGroupChannels int) {
-
-	totalChannelCount := 0
+// GetRemoteChannelIDs retrieves the IDs of the shared channels in a team whose home is another Mattermost instance.
+func GetRemoteChannelIDs(ctx context.Context, mmClient model.Client4, teamID string) (map[string]bool, error) {
+	DebugPrint("Getting remote shared channels for team ID: " + teamID)
 
-	fmt.Printf("\n\n")
-	fmt.Printf("Summary\n")
-	fmt.Printf("=======\n\n")
-	fmt.Printf("Username: %s\n", user.Username)
-	fmt.Printf("Email:    %s\n", user.Email)
-	fmt.Printf("Name:     %s %s\n", user.FirstName, user.LastName)
-	fmt.Printf("Nickname: %s\n\n", user.NickName)
-	fmt.Printf("Teams\n")
-	fmt.Printf("=====\n\n")
+	remoteChannels := make(map[string]bool)
 
-	// Figure out the longest team name to assist with formatting
-	maxTeamNameLength := 0
-	for _, team := range user.Teams {
-		if len(team.Name) > maxTeamNameLength {
-			maxTeamNameLength = len(team.Name)
+	for page := 0; ; page++ {
+		sharedChannels, err := callWithRetry(ctx, "GetAllSharedChannels", mmClient.URL, func() ([]*model.SharedChannel, *model.Response, error) {
+			return mmClient.GetAllSharedChannels(ctx, teamID, page, pageSize)
+		})
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// Add some padding
-	maxTeamNameLength += 2
+		for _, sharedChannel := range sharedChannels {
+			if !sharedChannel.Home {
+				remoteChannels[sharedChannel.ChannelId] = true
+			}
+		}
 
-	// Now we can print the Teams portion
-	for _, team := range user.Teams {
-		fmt.Printf("%-*s : %d\n", maxTeamNameLength, team.Name, team.ChannelCount)
-		totalChannelCount += team.ChannelCount
+		if len(sharedChannels) < pageSize {
+			break
+		}
 	}
 
-	fmt.Printf("\nDirect Message Channels : %d\n", totalDMChannels)
-	fmt.Printf("Group Message Channels  : %d\n", totalGroupChannels)
-	fmt.Printf("\nTotal channel count     : %d\n\n", totalChannelCount+totalDMChannels)
+	return remoteChannels, nil
 }
 
-func main() {
+// GetChannelMemberCount retrieves the number of members of a channel.
+func GetChannelMemberCount(ctx context.Context, mmClient model.Client4, channelID string) (int, error) {
+	DebugPrint("Getting member count for channel ID: " + channelID)
 
-	// Parse Command Line
-	DebugPrint("Parsing command line")
+	etag := ""
 
-	var MattermostURL string
-	var MattermostPort string
-	var MattermostScheme string
-	var MattermostToken string
-	var MattermostUser string
-	var DebugFlag bool
-	var VersionFlag bool
+	stats, err := callWithRetry(ctx, "GetChannelStats", mmClient.URL, func() (*model.ChannelStats, *model.Response, error) {
+		return mmClient.GetChannelStats(ctx, channelID, etag, true)
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	flag.StringVar(&MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
-	flag.StringVar(&MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: "+defaultPort+"]")
-	flag.StringVar(&MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
-	flag.StringVar(&MattermostToken, "token", "", "The auth token used to connect to Mattermost")
-	flag.StringVar(&MattermostUser, "user", "", "The username of the Mattermost user")
-	flag.BoolVar(&DebugFlag, "debug", false, "Enable debug output")
-	flag.BoolVar(&VersionFlag, "version", false, "Show version information and exit")
+	return int(stats.MemberCount), nil
+}
 
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n", os.Args[0])
-		fmt.Fprintln(flag.CommandLine.Output(), "This utility is used to find how many channels a users is member of.")
-		fmt.Fprintln(flag.CommandLine.Output(), "Options:")
-		flag.PrintDefaults()
-	}
+// GetPinnedPostCount retrieves the number of posts pinned in a channel.
+func GetPinnedPostCount(ctx context.Context, mmClient model.Client4, channelID string) (int, error) {
+	DebugPrint("Getting pinned posts for channel ID: " + channelID)
 
-	flag.Parse()
+	etag := ""
 
-	if VersionFlag {
-		fmt.Printf("mm-channel-count - Version: %s\n\n", Version)
-		os.Exit(0)
+	posts, err := callWithRetry(ctx, "GetPinnedPosts", mmClient.URL, func() (*model.PostList, *model.Response, error) {
+		return mmClient.GetPinnedPosts(ctx, channelID, etag)
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	// If information not supplied on the command line, check whether it's available as an envrionment variable
-	if MattermostURL == "" {
-		MattermostURL = getEnvWithDefault("MM_URL", "").(string)
+	return len(posts.Order), nil
+}
+
+// calculateMemberStats computes the average, minimum and maximum of a set of channel member counts.
+func calculateMemberStats(memberCounts []int) MemberStats {
+	var stats MemberStats
+	if len(memberCounts) == 0 {
+		return stats
 	}
-	if MattermostPort == "" {
-		MattermostPort = getEnvWithDefault("MM_PORT", defaultPort).(string)
+
+	total := 0
+	stats.Minimum = memberCounts[0]
+	for _, count := range memberCounts {
+		total += count
+		if count < stats.Minimum {
+			stats.Minimum = count
+		}
+		if count > stats.Maximum {
+			stats.Maximum = count
+		}
 	}
-	if MattermostScheme == "" {
-		MattermostScheme = getEnvWithDefault("MM_SCHEME", defaultScheme).(string)
+	stats.Average = float64(total) / float64(len(memberCounts))
+
+	return stats
+}
+
+// calculateMeanStdDev computes the arithmetic mean and (population) standard deviation of a set of counts.
+func calculateMeanStdDev(values []int) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
 	}
-	if MattermostToken == "" {
-		MattermostToken = getEnvWithDefault("MM_TOKEN", "").(string)
+
+	total := 0
+	for _, value := range values {
+		total += value
 	}
-	if !DebugFlag {
-		DebugFlag = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+	mean := float64(total) / float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		variance += math.Pow(float64(value)-mean, 2)
 	}
+	variance /= float64(len(values))
 
-	DebugMessage := fmt.Sprintf("Parameters: \n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  User=%s\n",
-		MattermostURL,
-		MattermostPort,
-		MattermostScheme,
-		MattermostToken,
-		MattermostUser)
-	DebugPrint(DebugMessage)
+	return mean, math.Sqrt(variance)
+}
 
-	// Validate required parameters
-	DebugPrint("Validating parameters")
-	var cliErrors bool = false
-	if MattermostURL == "" {
-		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
-		cliErrors = true
+// channelSelected reports whether a channel passes the filters in the options.  The channel type and system channel
+// exclusion filters are applied separately by the callers.  The members and remote channels are only needed for the
+// role and shared channel filters, and may be nil otherwise.  Direct and group messages have no name, purpose or
+// channel roles of their own, so those filters only apply to the team channels.
+func channelSelected(channel *model.Channel, options countOptions, members map[string]model.ChannelMember, remoteChannels map[string]bool) bool {
+	// The membership API doesn't expose a join date, so the channel's creation date is the closest approximation
+	if !options.since.IsZero() && time.UnixMilli(channel.CreateAt).Before(options.since) {
+		return false
 	}
-	if MattermostScheme == "" {
-		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
-		cliErrors = true
+	if options.onlySystemChannels && !isSystemChannel(channel) {
+		return false
 	}
-	if MattermostToken == "" {
-		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
-		cliErrors = true
+	teamChannel := !channel.IsGroupOrDirect()
+	if teamChannel && options.role != "" && !channelMemberHasRole(members[channel.Id], options.role) {
+		return false
 	}
-	if MattermostUser == "" {
-		LogMessage(errorLevel, "A Mattermost username is required to use this utility.")
-		cliErrors = true
+	if teamChannel && options.channelFilter != nil && !options.channelFilter.MatchString(channel.DisplayName) {
+		return false
 	}
-
-	if cliErrors {
-		flag.Usage()
-		os.Exit(1)
+	if teamChannel && options.purposeFilter != "" && !strings.Contains(strings.ToLower(channel.Purpose), options.purposeFilter) {
+		return false
 	}
-
-	debugMode = DebugFlag
-
-	// Prepare the Mattermost connection
-	mattermostConenction := mmConnection{
-		mmURL:    MattermostURL,
-		mmPort:   MattermostPort,
-		mmScheme: MattermostScheme,
-		mmToken:  MattermostToken,
+	if options.remoteOnly && !remoteChannels[channel.Id] {
+		return false
 	}
+	if !options.staleBefore.IsZero() && !time.UnixMilli(channel.LastPostAt).Before(options.staleBefore) {
+		return false
+	}
+	return true
+}
 
-	mmTarget := fmt.Sprintf("%s://%s:%s", mattermostConenction.mmScheme, mattermostConenction.mmURL, mattermostConenction.mmPort)
-
-	DebugPrint("Full target for Mattermost: " + mmTarget)
-	mmClient := model.NewAPIv4Client(mmTarget)
-	mmClient.SetToken(mattermostConenction.mmToken)
-	DebugPrint("Connected to Mattermost")
+// GetChannelCountForTeam counts the channels that the user is a member of within a team, applying the filters in the
+// options.  The user's channels are retrieved in a single request, as the Mattermost API doesn't paginate this
+// endpoint (it ignores any page parameters and always returns every membership), so there is no paged equivalent.
+// Direct and group messages aren't part of the team, so they are left to CountDirectMessageChannels.
+func GetChannelCountForTeam(ctx context.Context, mmClient model.Client4, teamID string, userID string, options countOptions) (channelCounts, error) {
+	DebugPrint("Getting channel count for team ID: " + teamID)
 
-	LogMessage(infoLevel, "Processing started - Version: "+Version)
+	var counts channelCounts
+	etag := ""
 
-	// Get the ID (and other information) of the user
-	user, err := GetUserIDFromUsername(*mmClient, MattermostUser)
+	channels, err := callWithRetry(ctx, "GetChannelsForTeamForUser", mmClient.URL, func() ([]*model.Channel, *model.Response, error) {
+		return mmClient.GetChannelsForTeamForUser(ctx, teamID, userID, options.includeArchived, etag)
+	})
 	if err != nil {
-		LogMessage(errorLevel, "Failed to retrieve user from Mattermost")
-		os.Exit(10)
+		logTeamRequestError("Failed to retrieve channels", err, options.guestSafe)
+		return counts, err
 	}
 
-	// Get the teams that this user is a member of
-	teams, err := GetTeamsForUser(*mmClient, user.ID)
+	// The unread state, roles and notification preferences are held against the user's channel membership, rather
+	// than the channel itself
+	var members map[string]model.ChannelMember
+	if options.countUnread || options.role != "" || options.countMuted {
+		members, err = GetChannelMembersForTeam(ctx, mmClient, teamID, userID)
+		if err != nil {
+			logTeamRequestError("Failed to retrieve channel memberships", err, options.guestSafe)
+			return counts, err
+		}
+	}
+
+	var remoteChannels map[string]bool
+	if options.remoteOnly {
+		remoteChannels, err = GetRemoteChannelIDs(ctx, mmClient, teamID)
+		if err != nil {
+			logTeamRequestError("Failed to retrieve shared channels", err, options.guestSafe)
+			return counts, err
+		}
+	}
+
+	var memberCounts []int
+	var matched []*model.Channel
+
+	for _, channel := range filterChannelsByType(channels, options.channelTypes, options.excludedChannelTypes) {
+		if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+			continue
+		}
+		if !channelSelected(channel, options, members, remoteChannels) {
+			continue
+		}
+		if options.excludeSystemChannels && isSystemChannel(channel) {
+			counts.SystemChannelsExcluded++
+			continue
+		}
+
+		matched = append(matched, channel)
+
+		if channel.DeleteAt != 0 {
+			counts.Archived++
+		}
+		// Shared channels are connected to one or more other Mattermost instances
+		if channel.IsShared() {
+			counts.Shared++
+		}
+		if member, ok := members[channel.Id]; ok && channel.TotalMsgCount > member.MsgCount {
+			counts.Unread++
+		}
+		if member, ok := members[channel.Id]; ok && member.IsChannelMuted() {
+			counts.Muted++
+		}
+		if options.favouriteChannels[channel.Id] {
+			counts.Favourites++
+		}
+
+		info := newChannelInfo(channel)
+		if options.memberStats {
+			info.MemberCount, err = GetChannelMemberCount(ctx, mmClient, channel.Id)
+			if err != nil {
+				logTeamRequestError("Failed to retrieve channel stats", err, options.guestSafe)
+				return counts, err
+			}
+			memberCounts = append(memberCounts, info.MemberCount)
+		}
+		if options.countPinned {
+			pinnedCount, err := GetPinnedPostCount(ctx, mmClient, channel.Id)
+			if err != nil {
+				logTeamRequestError("Failed to retrieve pinned posts", err, options.guestSafe)
+				return counts, err
+			}
+			counts.PinnedPosts += pinnedCount
+		}
+		if options.listChannels {
+			counts.ChannelList = append(counts.ChannelList, info)
+		}
+	}
+
+	counts.Channels = len(matched)
+	counts.ByType = GetChannelCountByType(matched)
+	// Both team channel types are always reported, so that a missing key doesn't have to be read as zero
+	for _, channelType := range []model.ChannelType{model.ChannelTypeOpen, model.ChannelTypePrivate} {
+		if _, ok := counts.ByType[string(channelType)]; !ok {
+			counts.ByType[string(channelType)] = 0
+		}
+	}
+
+	counts.MemberStats = calculateMemberStats(memberCounts)
+
+	return counts, nil
+}
+
+// GetChannelCountByType counts the channels of each type, keyed by the one-character Mattermost channel type (O, P,
+// D or G).  Types without any channels are left out.
+func GetChannelCountByType(channels []*model.Channel) map[string]int {
+	countsByType := make(map[string]int)
+	for _, channel := range channels {
+		countsByType[string(channel.Type)]++
+	}
+	return countsByType
+}
+
+// GetDirectMessageChannels retrieves the user's direct and group message channels.  These aren't tied to a team, so
+// they are retrieved along with all of the user's channels, rather than from a team query.
+func GetDirectMessageChannels(ctx context.Context, mmClient model.Client4, userID string) ([]*model.Channel, error) {
+	DebugPrint("Getting direct message channels for user ID: " + userID)
+
+	channels, err := callWithRetry(ctx, "GetChannelsForUserWithLastDeleteAt", mmClient.URL, func() ([]*model.Channel, *model.Response, error) {
+		return mmClient.GetChannelsForUserWithLastDeleteAt(ctx, userID, 0)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve channels: "+err.Error())
+		return nil, err
+	}
+
+	var dmChannels []*model.Channel
+	for _, channel := range channels {
+		if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+			dmChannels = append(dmChannels, channel)
+		}
+	}
+
+	return dmChannels, nil
+}
+
+// selectDirectMessageChannels returns the direct and group message channels that pass the filters in the options, so
+// that they're counted, listed and checked for inactivity consistently with the team channels.
+func selectDirectMessageChannels(channels []*model.Channel, options countOptions) []*model.Channel {
+	var selected []*model.Channel
+	for _, channel := range filterChannelsByType(channels, options.channelTypes, options.excludedChannelTypes) {
+		if channel.DeleteAt != 0 && !options.includeArchived {
+			continue
+		}
+		if channelSelected(channel, options, nil, nil) {
+			selected = append(selected, channel)
+		}
+	}
+	return selected
+}
+
+// CountDirectMessageChannels counts the direct and group message channels, returned by GetDirectMessageChannels and
+// filtered by selectDirectMessageChannels.
+func CountDirectMessageChannels(channels []*model.Channel) (int, int) {
+	countsByType := GetChannelCountByType(channels)
+	return countsByType[string(model.ChannelTypeDirect)], countsByType[string(model.ChannelTypeGroup)]
+}
+
+// CountInactiveDMChannels counts the direct and group message channels that have had no posts in the given number of
+// days, including those that have never been used.  The channels are those selected by selectDirectMessageChannels,
+// so the inactive count is a subset of the DM count.
+func CountInactiveDMChannels(channels []*model.Channel, days int) int {
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	inactiveCount := 0
+	for _, channel := range channels {
+		if channel.LastPostAt < cutoff {
+			inactiveCount++
+		}
+	}
+
+	return inactiveCount
+}
+
+// GetDMPartners returns the usernames of the other participants in the user's direct message channels, sorted
+// alphabetically.  The user's conversation with themselves is not included.
+func GetDMPartners(ctx context.Context, mmClient model.Client4, channels []*model.Channel, userID string) ([]string, error) {
+	var partnerIDs []string
+	for _, channel := range channels {
+		if partnerID := channel.GetOtherUserIdForDM(userID); partnerID != "" {
+			partnerIDs = append(partnerIDs, partnerID)
+		}
+	}
+	if len(partnerIDs) == 0 {
+		return nil, nil
+	}
+
+	DebugPrint(fmt.Sprintf("Getting usernames for %d direct message partners", len(partnerIDs)))
+	partners, err := callWithRetry(ctx, "GetUsersByIds", mmClient.URL, func() ([]*model.User, *model.Response, error) {
+		return mmClient.GetUsersByIds(ctx, partnerIDs)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve direct message partners: "+err.Error())
+		return nil, err
+	}
+
+	var usernames []string
+	for _, partner := range partners {
+		usernames = append(usernames, partner.Username)
+	}
+	slices.Sort(usernames)
+
+	return usernames, nil
+}
+
+// CountChannelsForTeams populates the user's channel count for each team, using a pool of workers to query Mattermost
+// in parallel.  An error is returned for each team that couldn't be counted.  DMs aren't part of any team, so they're
+// counted separately by CountDirectMessageChannels.  There's no flat list of the user's channels across all teams: the
+// per-team counts are needed for the output, the paged team query such a list would be built on isn't possible (see
+// GetChannelCountForTeam), and the DMs that would appear in every team are already retrieved only once.
+func CountChannelsForTeams(ctx context.Context, mmClient model.Client4, teams []Team, user User, options countOptions, concurrency int) []error {
+	DebugPrint(fmt.Sprintf("Counting channels for %d teams with concurrency %d", len(teams), concurrency))
+
+	if len(teams) == 0 {
+		return nil
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Favourites are a user preference, rather than being held per team, so they only need to be retrieved once
+	if options.countFavourites {
+		var err error
+		options.favouriteChannels, err = GetFavouriteChannelIDs(ctx, mmClient, user.ID)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to retrieve favourite channels for "+user.Username+" - favourites will not be counted")
+		}
+	}
+
+	jobs := make(chan int)
+	results := make(chan teamCountResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			for i := range jobs {
+				// Pausing between teams keeps a large run under the server's rate limit
+				if !first && options.rateLimitSleep > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(options.rateLimitSleep):
+					}
+				}
+				first = false
+
+				result := teamCountResult{index: i}
+				result.counts, result.err = GetChannelCountForTeam(ctx, mmClient, teams[i].ID, user.ID, options)
+				result.err = checkAccessDenied(result.err, options.guestSafe, teams[i].ID)
+				if result.err != nil {
+					results <- result
+					continue
+				}
+
+				// The extra details are supplementary to the channel count, so a failure to retrieve one of them is
+				// logged and the detail left empty, rather than failing the whole team
+				if options.teamRole {
+					role, err := GetUserTeamRole(ctx, mmClient, teams[i].ID, user.ID)
+					if err != nil {
+						LogMessage(warningLevel, "Failed to retrieve the team role for team "+teams[i].Name)
+					} else {
+						result.role = role
+					}
+				}
+				if options.teamMemberCount {
+					memberCount, err := GetTeamMemberCount(ctx, mmClient, teams[i].ID)
+					if err != nil {
+						LogMessage(warningLevel, "Failed to retrieve the member count for team "+teams[i].Name)
+					} else {
+						result.memberCount = memberCount
+					}
+				}
+				if options.teamSummary {
+					channelCount, err := GetTeamChannelCount(ctx, mmClient, teams[i].ID)
+					if err != nil {
+						LogMessage(warningLevel, "Failed to retrieve the total channel count for team "+teams[i].Name)
+					} else {
+						result.channelCount = channelCount
+					}
+				}
+				if options.sidebarCategories {
+					categories, err := GetSidebarCategoryCounts(ctx, mmClient, teams[i].ID, user.ID)
+					if err != nil {
+						LogMessage(warningLevel, "Failed to retrieve the sidebar categories for team "+teams[i].Name)
+					} else {
+						result.categories = categories
+					}
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		for i := range teams {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var teamErrors []error
+
+	for result := range results {
+		if errors.Is(result.err, errAccessDenied) {
+			teams[result.index].AccessDenied = true
+			if options.teamCounted != nil {
+				options.teamCounted(user, teams[result.index])
+			}
+			continue
+		}
+		if result.err != nil {
+			LogMessage(warningLevel, "Failed to get channel count for team "+teams[result.index].Name)
+			teamErrors = append(teamErrors, fmt.Errorf("team %s: %w", teams[result.index].Name, result.err))
+			failedTeam := teams[result.index]
+			failedTeam.ChannelCount = -1
+			failedTeam.Error = result.err.Error()
+			// Flag the team, rather than leaving a count of zero that would silently understate the total
+			if options.gracefulDegradation {
+				teams[result.index] = failedTeam
+			}
+			if options.teamCounted != nil {
+				options.teamCounted(user, failedTeam)
+			}
+			continue
+		}
+		// Joining a team always adds the user to its default channels, so an empty result is unexpected
+		// unless the channels are being filtered
+		if result.counts.Channels == 0 && !options.filtered() {
+			LogMessage(warningLevel, "No channels counted for team "+teams[result.index].Name+" - the user is on the team but has no matching channel memberships")
+		}
+		teams[result.index].ChannelCount = result.counts.Channels
+		teams[result.index].ChannelsByType = result.counts.ByType
+		teams[result.index].UnreadCount = result.counts.Unread
+		teams[result.index].MemberStats = result.counts.MemberStats
+		teams[result.index].Channels = result.counts.ChannelList
+		teams[result.index].SystemChannelsExcluded = result.counts.SystemChannelsExcluded
+		teams[result.index].ArchivedChannelCount = result.counts.Archived
+		teams[result.index].SharedChannelCount = result.counts.Shared
+		teams[result.index].MutedChannelCount = result.counts.Muted
+		teams[result.index].FavouriteChannelCount = result.counts.Favourites
+		teams[result.index].PinnedPostCount = result.counts.PinnedPosts
+		teams[result.index].Role = result.role
+		teams[result.index].TeamMemberCount = result.memberCount
+		teams[result.index].TeamChannelCount = result.channelCount
+		teams[result.index].SidebarCategories = result.categories
+		if options.teamCounted != nil {
+			options.teamCounted(user, teams[result.index])
+		}
+	}
+
+	return teamErrors
+}
+
+func GetTeamsForUser(ctx context.Context, mmClient model.Client4, userID string) ([]Team, error) {
+
+	DebugPrint("Getting teams for user ID: " + userID)
+
+	etag := ""
+
+	teams, err := callWithRetry(ctx, "GetTeamsForUser", mmClient.URL, func() ([]*model.Team, *model.Response, error) {
+		return mmClient.GetTeamsForUser(ctx, userID, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve teams: "+err.Error())
+		return nil, err
+	}
+
+	var teamsList []Team
+
+	for _, mmTeam := range teams {
+		team := Team{
+			Name: mmTeam.DisplayName,
+			ID:   mmTeam.Id,
+		}
+
+		teamsList = append(teamsList, team)
+	}
+
+	return teamsList, nil
+}
+
+// GetUserTeamRole returns the user's role within a team: admin, member or guest.
+func GetUserTeamRole(ctx context.Context, mmClient model.Client4, teamID string, userID string) (string, error) {
+	DebugPrint("Getting team role for team ID: " + teamID)
+
+	etag := ""
+
+	member, err := callWithRetry(ctx, "GetTeamMember", mmClient.URL, func() (*model.TeamMember, *model.Response, error) {
+		return mmClient.GetTeamMember(ctx, teamID, userID, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve team membership: "+err.Error())
+		return "", err
+	}
+
+	// Roles granted by the team's scheme are flagged separately, rather than being listed in Roles
+	roles := strings.Fields(member.Roles)
+	switch {
+	case member.SchemeAdmin || slices.Contains(roles, model.TeamAdminRoleId):
+		return "admin", nil
+	case member.SchemeGuest || slices.Contains(roles, model.TeamGuestRoleId):
+		return "guest", nil
+	}
+	return "member", nil
+}
+
+// GetTeamMemberCount returns the total number of members of a team, which gives some context to the user's channel
+// count: a large number of channels is more usual in a large team than in a small one.
+func GetTeamMemberCount(ctx context.Context, mmClient model.Client4, teamID string) (int, error) {
+	DebugPrint("Getting member count for team ID: " + teamID)
+
+	etag := ""
+
+	stats, err := callWithRetry(ctx, "GetTeamStats", mmClient.URL, func() (*model.TeamStats, *model.Response, error) {
+		return mmClient.GetTeamStats(ctx, teamID, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve team statistics: "+err.Error())
+		return 0, err
+	}
+
+	return int(stats.TotalMemberCount), nil
+}
+
+// GetSidebarCategoryCounts returns the number of channels in each of the user's sidebar categories for a team.
+func GetSidebarCategoryCounts(ctx context.Context, mmClient model.Client4, teamID string, userID string) ([]SidebarCategoryCount, error) {
+	DebugPrint("Getting sidebar categories for team ID: " + teamID)
+
+	etag := ""
+
+	sidebar, err := callWithRetry(ctx, "GetSidebarCategoriesForTeamForUser", mmClient.URL, func() (*model.OrderedSidebarCategories, *model.Response, error) {
+		return mmClient.GetSidebarCategoriesForTeamForUser(ctx, userID, teamID, etag)
+	})
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve sidebar categories: "+err.Error())
+		return nil, err
+	}
+
+	var categories []SidebarCategoryCount
+	for _, category := range sidebar.Categories {
+		categories = append(categories, SidebarCategoryCount{Name: category.DisplayName, ChannelCount: len(category.Channels)})
+	}
+
+	return categories, nil
+}
+
+// isExcludedTeam reports whether a team matches any of the supplied exclusions, either by display name or by ID.
+func isExcludedTeam(team Team, exclusions []string) bool {
+	for _, exclusion := range exclusions {
+		if strings.EqualFold(exclusion, team.Name) || strings.EqualFold(exclusion, team.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectTeam returns the team matching either the display name or the ID, ignoring case, or an empty list if the
+// user isn't a member of a matching team.
+func selectTeam(teams []Team, name string, id string) []Team {
+	for _, team := range teams {
+		if (name != "" && strings.EqualFold(team.Name, name)) || (id != "" && strings.EqualFold(team.ID, id)) {
+			return []Team{team}
+		}
+	}
+	return nil
+}
+
+// printUserDetails prints the details of the resolved user, as displayed at the top of the summary.
+func printUserDetails(user User) {
+	fmt.Printf("Lookup:   %s\n", user.LookupField)
+	if user.Deactivated {
+		fmt.Printf("Username: %s (deactivated)\n", user.Username)
+	} else {
+		fmt.Printf("Username: %s\n", user.Username)
+	}
+	fmt.Printf("Email:    %s\n", user.Email)
+	fmt.Printf("Name:     %s %s\n", user.FirstName, user.LastName)
+	fmt.Printf("Nickname: %s\n\n", user.NickName)
+}
+
+// isZeroTeam reports whether the user has no channels in a team that was counted successfully.
+func isZeroTeam(team Team) bool {
+	return team.ChannelCount == 0 && !team.AccessDenied && team.Error == ""
+}
+
+// suppressZeroTeams returns the teams in which the user has at least one channel, along with the number of teams that
+// were left out.  Teams that couldn't be counted are kept, so that the failure is still reported.
+func suppressZeroTeams(teams []Team) ([]Team, int) {
+	var includedTeams []Team
+	for _, team := range teams {
+		if isZeroTeam(team) {
+			continue
+		}
+		includedTeams = append(includedTeams, team)
+	}
+	return includedTeams, len(teams) - len(includedTeams)
+}
+
+// filterExcludedTeams returns the teams that don't match any of the supplied exclusions.
+func filterExcludedTeams(teams []Team, exclusions []string) []Team {
+	var includedTeams []Team
+	for _, team := range teams {
+		if isExcludedTeam(team, exclusions) {
+			DebugPrint("Excluding team: " + team.Name)
+			continue
+		}
+		includedTeams = append(includedTeams, team)
+	}
+	return includedTeams
+}
+
+func PrintSummary(user User, totalDMChannels int, totalGroupChannels int, options summaryOptions) {
+
+	totalUnreadCount := 0
+	totalSystemChannelsExcluded := 0
+	totalSharedChannels := 0
+	totalMutedChannels := 0
+	totalFavouriteChannels := 0
+	failedTeams := 0
+
+	if !options.noHeader {
+		fmt.Printf("\n\n")
+		fmt.Printf("Summary\n")
+		fmt.Printf("=======\n\n")
+	}
+	printUserDetails(user)
+	if !options.noHeader {
+		fmt.Printf("Teams\n")
+		fmt.Printf("=====\n\n")
+	}
+
+	// Figure out the longest team name to assist with formatting, and the totals across all teams
+	maxTeamNameLength := 0
+	for _, team := range user.Teams {
+		if len(team.Name) > maxTeamNameLength {
+			maxTeamNameLength = len(team.Name)
+		}
+		totalUnreadCount += team.UnreadCount
+		totalSystemChannelsExcluded += team.SystemChannelsExcluded
+		totalSharedChannels += team.SharedChannelCount
+		totalMutedChannels += team.MutedChannelCount
+		totalFavouriteChannels += team.FavouriteChannelCount
+		if team.Error != "" {
+			failedTeams++
+		}
+	}
+	grandTotal := user.TotalChannelCount
+
+	// Add some padding, unless a fixed width has been requested
+	maxTeamNameLength += 2
+	if options.nameWidth > 0 {
+		maxTeamNameLength = options.nameWidth
+	}
+
+	// Now we can print the Teams portion
+	if len(user.Teams) == 0 && options.suppressedTeams == 0 {
+		fmt.Println("User is not a member of any team")
+	}
+	for _, team := range user.Teams {
+		if team.AccessDenied {
+			fmt.Printf("%s : access denied\n", padRight(ansiCyan, team.Name, maxTeamNameLength))
+			continue
+		}
+		if team.Error != "" {
+			fmt.Printf("%s : error\n", padRight(ansiCyan, team.Name, maxTeamNameLength))
+			continue
+		}
+
+		line := padRight(ansiCyan, team.Name, maxTeamNameLength) + " : " + padRight(ansiGreen, strconv.Itoa(team.ChannelCount), 6)
+		if options.showPercent {
+			percent := 0.0
+			if grandTotal > 0 {
+				percent = float64(team.ChannelCount) / float64(grandTotal) * 100
+			}
+			line += fmt.Sprintf(" %6.2f%%  ", percent)
+		}
+		if options.showUnread {
+			line += fmt.Sprintf(" Unread: %-6d", team.UnreadCount)
+		}
+		if options.showPinned {
+			line += fmt.Sprintf(" Pinned: %-6d", team.PinnedPostCount)
+		}
+		if options.showFavourites {
+			line += fmt.Sprintf(" Favourited: %-6d", team.FavouriteChannelCount)
+		}
+		if options.showMuted {
+			line += fmt.Sprintf(" Muted: %-6d", team.MutedChannelCount)
+		}
+		if options.showTeamRole {
+			line += fmt.Sprintf(" Role: %-6s", team.Role)
+		}
+		if options.showTeamMemberCount {
+			line += fmt.Sprintf(" Team members: %-6d", team.TeamMemberCount)
+		}
+		if options.showMemberStats {
+			line += fmt.Sprintf(" Members (avg/min/max): %.2f/%d/%d", team.MemberStats.Average, team.MemberStats.Minimum, team.MemberStats.Maximum)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+		if line := formatChannelsByType(team.ChannelsByType, options); line != "" {
+			fmt.Printf("    %s\n", line)
+		}
+		if options.showArchived {
+			fmt.Printf("    of which archived: %d\n", team.ArchivedChannelCount)
+		}
+		// Every team has at least one channel, so a total of zero means it couldn't be retrieved
+		if options.showTeamSummary && team.TeamChannelCount > 0 {
+			fmt.Printf("    member of %d of the team's %d channels\n", team.ChannelCount, team.TeamChannelCount)
+		}
+		if options.showSidebarCategories {
+			for _, category := range team.SidebarCategories {
+				fmt.Printf("    %s: %d\n", category.Name, category.ChannelCount)
+			}
+		}
+
+		if options.listChannels {
+			for _, channel := range team.Channels {
+				fmt.Printf("    %s (%s) - last post %s\n", channel.DisplayName, describeChannelType(channel.Type), formatLastPostAge(channel.LastPostAt))
+				if options.verbose {
+					fmt.Printf("        Purpose: %s\n", truncate(channel.Purpose, maxVerboseFieldLength))
+					fmt.Printf("        Header:  %s\n", truncate(channel.Header, maxVerboseFieldLength))
+				}
+			}
+		}
+	}
+
+	fmt.Println()
+	if !options.hideDMs {
+		if options.dmChannelsFailed {
+			fmt.Println(colorize(ansiYellow, "Direct Message Channels : not counted"))
+			fmt.Println(colorize(ansiYellow, "Group Message Channels  : not counted"))
+		} else {
+			fmt.Println(colorize(ansiYellow, fmt.Sprintf("Direct Message Channels : %d", totalDMChannels)))
+			for _, partner := range user.DMPartners {
+				fmt.Printf("    %s\n", partner)
+			}
+			fmt.Println(colorize(ansiYellow, fmt.Sprintf("Group Message Channels  : %d", totalGroupChannels)))
+		}
+		if options.inactiveDMDays > 0 {
+			fmt.Printf("%-24s: %d\n", fmt.Sprintf("Inactive DMs (>%d days)", options.inactiveDMDays), user.InactiveDMCount)
+		}
+	}
+	if options.showUnread {
+		fmt.Printf("Unread Channels         : %d\n", totalUnreadCount)
+	}
+	if options.showSystemChannelsExcluded {
+		fmt.Printf("System Channels Excluded: %d\n", totalSystemChannelsExcluded)
+	}
+	if options.showFavourites {
+		fmt.Printf("Favourite Channels      : %d\n", totalFavouriteChannels)
+	}
+	if options.showMuted {
+		fmt.Printf("Muted Channels          : %d\n", totalMutedChannels)
+	}
+	if options.showShared {
+		fmt.Printf("Shared Channels         : %d\n", totalSharedChannels)
+	}
+	if options.instanceTeamCount > 0 {
+		fmt.Printf("Member of %d out of %d total teams\n", options.memberTeamCount, options.instanceTeamCount)
+	}
+	if failedTeams > 0 {
+		fmt.Printf("Teams not counted       : %d\n", failedTeams)
+	}
+	if options.suppressedTeams > 0 {
+		fmt.Printf("Teams with no channels  : %d (not shown)\n", options.suppressedTeams)
+	}
+	fmt.Printf("\n%s\n", colorize(ansiBold, fmt.Sprintf("Total channel count     : %d", grandTotal)))
+	if options.showChannelCountDelta {
+		if options.channelCountDelta != nil {
+			fmt.Printf("Change since last run   : %+d\n", *options.channelCountDelta)
+		} else {
+			fmt.Println("Change since last run   : no previous run saved")
+		}
+	}
+	fmt.Println()
+
+	if options.showStats {
+		var teamCounts []int
+		for _, team := range user.Teams {
+			teamCounts = append(teamCounts, team.ChannelCount)
+		}
+		mean, stdDev := calculateMeanStdDev(teamCounts)
+		fmt.Printf("Per-team mean: %.2f  Standard deviation: %.2f\n\n", mean, stdDev)
+	}
+}
+
+// exitHandlers are run, most recently registered first, when the program terminates via exit.
+var exitHandlers []func()
+
+// atExit registers a function to be run when the program terminates via exit.
+func atExit(handler func()) {
+	exitHandlers = append(exitHandlers, handler)
+}
+
+// exit runs any registered exit handlers, then terminates the program with the given code.
+func exit(code int) {
+	for i := len(exitHandlers) - 1; i >= 0; i-- {
+		exitHandlers[i]()
+	}
+	os.Exit(code)
+}
+
+func main() {
+
+	// Parse Command Line
+	DebugPrint("Parsing command line")
+
+	var MattermostURL string
+	var MattermostPort string
+	var MattermostScheme string
+	var MattermostToken string
+	var LoginUsername string
+	var LoginPassword string
+	var MattermostUser string
+	var MattermostEmail string
+	var MattermostUserID string
+	var DebugFlag bool
+	var VersionFlag bool
+	var ExcludeTeams stringListFlag
+	var ChannelTypeList string
+	var ExcludeChannelTypeList string
+	var Concurrency int
+	var CountUnreadFlag bool
+	var MemberStatsFlag bool
+	var Since string
+	var SinceDays int
+	var NoSystemChannelsFlag bool
+	var OnlySystemChannelsFlag bool
+	var Role string
+	var ShowPercentFlag bool
+	var Format string
+	var Width int
+	var DiffFlag bool
+	var SaveDir string
+	var StatsFlag bool
+	var MaxTeams int
+	var ListChannelsFlag bool
+	var VerboseFlag bool
+	var ChannelFilter string
+	var NameWidth int
+	var NoHeaderFlag bool
+	var UserInfoOnlyFlag bool
+	var TeamsOnlyFlag bool
+	var DMOnlyFlag bool
+	var ConfigPath string
+	var InstanceName string
+	var AllUsersFlag bool
+	var FindHeavyUsers int
+	var StdinUsersFlag bool
+	var TeamAllFlag bool
+	var ChannelCountOnlyFlag bool
+	var NoDMFlag bool
+	var GuestSafeFlag bool
+	var DeactivatedFlag bool
+	var WebhookURL string
+	var AlertWebhookURL string
+	var ThresholdWarn int
+	var ThresholdError int
+	var ColorFlag bool
+	var NoColorFlag bool
+	var ProgressFlag bool
+	var RetryDelay time.Duration
+	var NoPortFlag bool
+	var IncludeArchivedFlag bool
+	var PurposeFilter string
+	var TeamRoleFlag bool
+	var TeamMemberCountFlag bool
+	var TeamSummaryFlag bool
+	var ShowInstanceTeamsFlag bool
+	var ShowDMPartnersFlag bool
+	var InactiveDMDays int
+	var ChannelStatsCSV string
+	var SharedChannelsFlag bool
+	var RemoteOnlyFlag bool
+	var MutedChannelsFlag bool
+	var FavouriteChannelsFlag bool
+	var SidebarCategoriesFlag bool
+	var ChannelPurposeCSV string
+	var StaleDays int
+	var CountPinnedFlag bool
+	var GracefulDegradationFlag bool
+	var PartialResultsOKFlag bool
+	var SuppressZeroTeamsFlag bool
+	var ProfilePath string
+	var RateLimitSleep time.Duration
+	var MemProfilePath string
+	var TeamName string
+	var TeamID string
+	var VersionCheckFlag bool
+	var TrendFlag bool
+	var SkipIfUnchangedFlag bool
+	var ChannelCountDeltaFlag bool
+	var MaxChannelCount int
+
+	flag.StringVar(&MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
+	flag.StringVar(&MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: 443 for https, 80 for http, otherwise "+defaultPort+"]")
+	flag.BoolVar(&NoPortFlag, "no-port", false, "Omit the port from the Mattermost URL, e.g. when behind a reverse proxy on the standard port")
+	flag.StringVar(&MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
+	flag.StringVar(&MattermostToken, "token", "", "The auth token used to connect to Mattermost")
+	flag.StringVar(&LoginUsername, "username", "", "The username to log in with, if no auth token is supplied")
+	flag.StringVar(&LoginPassword, "password", "", "The password to log in with, if no auth token is supplied")
+	flag.StringVar(&ConfigPath, "config", "", "A JSON configuration file containing the details of one or more Mattermost instances")
+	flag.StringVar(&InstanceName, "instance", "", "The name of the instance to use from the configuration file")
+	flag.StringVar(&MattermostUser, "user", "", "The username of the Mattermost user")
+	flag.StringVar(&MattermostEmail, "email", "", "The email address of the Mattermost user (alternative to -user)")
+	flag.StringVar(&MattermostUserID, "user-id", "", "The ID of the Mattermost user (alternative to -user)")
+	flag.StringVar(&TeamName, "team", "", "Only count the channels in the team with this display name")
+	flag.StringVar(&TeamID, "team-id", "", "Only count the channels in the team with this ID (alternative to -team)")
+	flag.Var(&ExcludeTeams, "exclude-team", "A team (display name or ID) to exclude from the count. May be specified multiple times")
+	flag.StringVar(&ChannelTypeList, "channel-type", "", "Comma-separated list of channel types to count (O=public, P=private, D=direct, G=group). [Default: all]")
+	flag.StringVar(&ExcludeChannelTypeList, "exclude-channel-type", "", "Comma-separated list of channel types not to count (O=public, P=private, D=direct, G=group)")
+	flag.IntVar(&Concurrency, "concurrency", 1, "The number of teams to query in parallel")
+	flag.BoolVar(&CountUnreadFlag, "count-unread", false, "Also report how many channels in each team have unread messages")
+	flag.BoolVar(&MemberStatsFlag, "member-stats", false, "Also report the average, minimum and maximum channel member counts for each team")
+	flag.StringVar(&Since, "since", "", "Only count channels created on or after this date (RFC 3339 or YYYY-MM-DD)")
+	flag.IntVar(&SinceDays, "since-days", 0, "Only count channels created in the last N days")
+	flag.BoolVar(&NoSystemChannelsFlag, "no-system-channels", false, "Exclude the automatically created Town Square and Off-Topic channels from the count")
+	flag.BoolVar(&OnlySystemChannelsFlag, "only-system-channels", false, "Only count the automatically created Town Square and Off-Topic channels")
+	flag.StringVar(&Role, "role", "", "Only count channels where the user holds this role (admin/member/guest)")
+	flag.BoolVar(&ShowPercentFlag, "show-percent", false, "Show each team's channel count as a percentage of the overall total")
+	flag.StringVar(&Format, "format", formatText, "The output format (text/bar-chart/csv/tsv/json/xml/ndjson/html/dot)")
+	flag.IntVar(&Width, "width", 0, "The maximum width of the bar chart. [Default: terminal width]")
+	flag.BoolVar(&DiffFlag, "diff", false, "Compare two JSON reports, supplied as arguments, and exit")
+	flag.StringVar(&SaveDir, "save", "", "A directory in which to save a timestamped JSON report of the run")
+	flag.BoolVar(&SkipIfUnchangedFlag, "skip-if-unchanged", false, "Exit without any output, or saving a report, if the counts are the same as the last run saved in the -save directory")
+	flag.BoolVar(&ChannelCountDeltaFlag, "channel-count-delta", false, "Show the change in the total channel count since the last run saved in the -save directory")
+	flag.BoolVar(&TrendFlag, "trend", false, "Include the total channel count from each run saved in the -save directory in the JSON output")
+	flag.BoolVar(&StatsFlag, "stats", false, "Show the mean and standard deviation of the per-team channel counts")
+	flag.IntVar(&MaxTeams, "max-teams", 0, "Warn and exit with code 4 if the user is a member of more than this many teams")
+	flag.BoolVar(&ListChannelsFlag, "list-channels", false, "List the name and type of each channel, as well as the counts")
+	flag.BoolVar(&VerboseFlag, "verbose", false, "Include each channel's purpose and header when listing channels")
+	flag.IntVar(&StaleDays, "stale-days", 0, "Only count channels with no posts in this many days")
+	flag.StringVar(&ChannelFilter, "channel-filter", "", "A regular expression; only channels whose display names match are counted")
+	flag.StringVar(&PurposeFilter, "channel-purpose-filter", "", "Only count channels whose purpose contains this text (case-insensitive)")
+	flag.IntVar(&NameWidth, "name-width", 0, "A fixed width for the team name column of the summary. [Default: auto]")
+	flag.BoolVar(&NoHeaderFlag, "no-header", false, "Omit the headings from the text summary and the header row from CSV/TSV output")
+	flag.BoolVar(&UserInfoOnlyFlag, "user-info-only", false, "Print the details of the resolved user and exit, without counting channels")
+	flag.BoolVar(&TeamsOnlyFlag, "teams-only", false, "List the user's teams and exit, without counting channels")
+	flag.BoolVar(&DMOnlyFlag, "dm-only", false, "Only report the direct and group message channel counts, without querying any teams")
+	flag.BoolVar(&AllUsersFlag, "all-users", false, "Count the channels for every active user on the instance (requires a sysadmin token)")
+	flag.IntVar(&FindHeavyUsers, "find-heavy-users", 0, "List every active user on the instance in more than this many channels (requires a sysadmin token)")
+	flag.BoolVar(&StdinUsersFlag, "stdin-users", false, "Count the channels for each username read from stdin, one per line")
+	flag.BoolVar(&TeamAllFlag, "team-all", false, "Count all of the channels in every team on the instance, rather than for a user (requires a sysadmin token)")
+	flag.BoolVar(&ChannelCountOnlyFlag, "channel-count-only", false, "Print only the total channel count (including DMs) as a single integer")
+	flag.BoolVar(&NoDMFlag, "no-dm", false, "Don't count direct or group message channels")
+	flag.BoolVar(&GuestSafeFlag, "guest-safe", false, "Treat access denied (403) responses as warnings, marking the affected teams rather than failing")
+	flag.BoolVar(&GracefulDegradationFlag, "graceful-degradation", false, "Carry on when teams can't be counted, marking them as errors with a channel count of -1")
+	flag.BoolVar(&PartialResultsOKFlag, "partial-results-ok", false, "With -graceful-degradation, exit with code 0 as long as at least one team was counted")
+	flag.BoolVar(&DeactivatedFlag, "deactivated", false, "Report on deactivated users, tagging the output and exiting with code 3")
+	flag.StringVar(&WebhookURL, "webhook-url", "", "A Mattermost or Slack incoming webhook URL to post the summary to")
+	flag.IntVar(&ThresholdWarn, "threshold-warn", 0, "Warn and exit with code 4 if the total channel count exceeds this value")
+	flag.IntVar(&ThresholdError, "threshold-error", 0, "Log an error and exit with code 2 if the total channel count exceeds this value")
+	flag.IntVar(&MaxChannelCount, "max-channel-count", 0, "Log an error and exit immediately with code 2, without any output, if the total channel count exceeds this value")
+	flag.StringVar(&AlertWebhookURL, "alert-webhook", "", "An incoming webhook URL to post to only when a threshold is exceeded")
+	flag.BoolVar(&ColorFlag, "color", false, "Force coloured text output. [Default: auto-detected]")
+	flag.BoolVar(&NoColorFlag, "no-color", false, "Disable coloured text output")
+	flag.BoolVar(&ProgressFlag, "progress", false, "Show a spinner on stderr while waiting for Mattermost")
+	flag.DurationVar(&RetryDelay, "retry-delay", defaultRetryDelay, "The delay before retrying a failed request to Mattermost, doubling on each attempt")
+	flag.DurationVar(&RateLimitSleep, "rate-limit-sleep", 0, "A fixed pause between counting each team, to avoid being rate limited by Mattermost (e.g. 200ms)")
+	flag.BoolVar(&IncludeArchivedFlag, "include-archived", false, "Also count archived channels, reporting how many of each team's channels are archived")
+	flag.BoolVar(&TeamRoleFlag, "team-role", false, "Also show the user's role (admin/member/guest) in each team")
+	flag.BoolVar(&TeamMemberCountFlag, "team-member-count", false, "Also show the total number of members of each team")
+	flag.BoolVar(&TeamSummaryFlag, "team-summary", false, "Also show the total number of channels in each team, alongside the number the user is a member of")
+	flag.BoolVar(&SuppressZeroTeamsFlag, "suppress-zero-teams", false, "Leave out the teams in which the user has no (matching) channels, showing how many were left out")
+	flag.BoolVar(&ShowInstanceTeamsFlag, "show-instance-teams", false, "Also show the total number of teams on the instance (requires a sysadmin token to include private teams)")
+	flag.BoolVar(&ShowDMPartnersFlag, "show-dm-partners", false, "Also list the usernames of the user's direct message partners")
+	flag.IntVar(&InactiveDMDays, "inactive-dm-days", 0, "Also count the direct and group message channels with no posts in this many days")
+	flag.StringVar(&ChannelStatsCSV, "channel-stats-csv", "", "Also write a CSV file with the member count and activity dates of every channel")
+	flag.BoolVar(&SharedChannelsFlag, "shared-channels", false, "Also report how many of the user's channels are shared with other Mattermost instances")
+	flag.BoolVar(&RemoteOnlyFlag, "remote-only", false, "Only count shared channels whose home is another Mattermost instance")
+	flag.BoolVar(&MutedChannelsFlag, "muted-channels", false, "Also report how many channels in each team the user has muted")
+	flag.BoolVar(&FavouriteChannelsFlag, "favourite-channels", false, "Also report how many channels in each team the user has marked as favourites")
+	flag.BoolVar(&SidebarCategoriesFlag, "sidebar-categories", false, "Also show how many channels are in each of the user's sidebar categories for each team")
+	flag.StringVar(&ChannelPurposeCSV, "channel-purpose-csv", "", "Also write a CSV file with the purpose of every channel")
+	flag.BoolVar(&CountPinnedFlag, "count-pinned", false, "Also report the number of pinned posts across the user's channels in each team")
+	flag.BoolVar(&VersionCheckFlag, "version-check", false, "Warn if the Mattermost server's version is outside the range supported by this tool")
+	flag.StringVar(&ProfilePath, "profile", "", "Write a pprof CPU profile of the run to this file (for debugging)")
+	flag.StringVar(&MemProfilePath, "memprofile", "", "Write a pprof heap profile to this file on exit (for debugging)")
+	flag.BoolVar(&DebugFlag, "debug", false, "Enable debug output")
+	flag.BoolVar(&VersionFlag, "version", false, "Show version information and exit")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s -diff before.json after.json\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "This utility is used to find how many channels a users is member of.")
+		fmt.Fprintln(flag.CommandLine.Output(), "Options:")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		printExitCodes(flag.CommandLine.Output())
+	}
+
+	flag.Parse()
+
+	if VersionFlag {
+		fmt.Printf("mm-channel-count - Version: %s\n\n", Version)
+		os.Exit(ExitOK)
+	}
+
+	// The diff mode works entirely from saved reports, so doesn't need a Mattermost connection
+	if DiffFlag {
+		if err := RunDiff(flag.Args()); err != nil {
+			LogMessage(errorLevel, "Failed to compare reports: "+err.Error())
+			os.Exit(ExitDiffError)
+		}
+		os.Exit(ExitOK)
+	}
+
+	// Explicitly setting an empty port is equivalent to -no-port, rather than falling back to the default
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" && MattermostPort == "" {
+			NoPortFlag = true
+		}
+	})
+
+	// Connection details can also come from a named instance in the configuration file, at a lower priority than
+	// both the command line and the environment
+	var instance instanceConfig
+	if ConfigPath == "" {
+		ConfigPath = getEnvWithDefault("MM_CONFIG", "").(string)
+	}
+	if ConfigPath != "" {
+		config, err := LoadConfig(ConfigPath)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to load configuration file: "+err.Error())
+			os.Exit(ExitBadArgs)
+		}
+		instance, err = config.SelectInstance(InstanceName)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to select Mattermost instance: "+err.Error())
+			os.Exit(ExitBadArgs)
+		}
+	} else if InstanceName != "" {
+		LogMessage(errorLevel, "The -instance flag requires a configuration file, supplied with -config or MM_CONFIG")
+		os.Exit(ExitBadArgs)
+	}
+
+	// If information not supplied on the command line, check whether it's available as an envrionment variable
+	if MattermostURL == "" {
+		MattermostURL = getEnvWithDefault("MM_URL", instance.URL).(string)
+	}
+	if MattermostScheme == "" {
+		MattermostScheme = getEnvWithDefault("MM_SCHEME", instance.Scheme).(string)
+	}
+	// A scheme included in the URL is removed, and used unless a scheme has been given, before the port is defaulted
+	if host, urlScheme := stripURLScheme(MattermostURL); urlScheme != "" {
+		if MattermostScheme == "" {
+			MattermostScheme = urlScheme
+			LogMessage(warningLevel, "The Mattermost URL should not include the HTTP scheme - removing "+urlScheme+":// and using the "+MattermostScheme+" scheme")
+		} else {
+			LogMessage(warningLevel, "The Mattermost URL should not include the HTTP scheme - removing "+urlScheme+":// and using the "+MattermostScheme+" scheme instead")
+		}
+		MattermostURL = host
+	}
+	if MattermostPort == "" && !NoPortFlag {
+		MattermostPort = getEnvWithDefault("MM_PORT", valueOrDefault(instance.Port, defaultPortForScheme(MattermostScheme))).(string)
+	}
+	if MattermostScheme == "" {
+		MattermostScheme = defaultScheme
+	}
+	if MattermostToken == "" {
+		MattermostToken = getEnvWithDefault("MM_TOKEN", instance.Token).(string)
+	}
+	if LoginUsername == "" {
+		LoginUsername = getEnvWithDefault("MM_USERNAME", "").(string)
+	}
+	if LoginPassword == "" {
+		LoginPassword = getEnvWithDefault("MM_PASSWORD", "").(string)
+	}
+	if !DebugFlag {
+		DebugFlag = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+	}
+
+	DebugMessage := fmt.Sprintf("Parameters: \n  MattermostURL=%s\n  MattermostPort=%s\n  MattermostScheme=%s\n  MattermostToken=%s\n  User=%s\n  Email=%s\n  UserID=%s\n",
+		MattermostURL,
+		MattermostPort,
+		MattermostScheme,
+		MattermostToken,
+		MattermostUser,
+		MattermostEmail,
+		MattermostUserID)
+	DebugPrint(DebugMessage)
+
+	// Validate required parameters
+	DebugPrint("Validating parameters")
+	var cliErrors bool = false
+	if MattermostURL == "" {
+		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+		cliErrors = true
+	}
+	if MattermostScheme == "" {
+		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
+		cliErrors = true
+	}
+	if MattermostToken == "" && (LoginUsername == "" || LoginPassword == "") {
+		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable, or a username and password supplied with -username and -password")
+		cliErrors = true
+	}
+	userLookups := 0
+	for _, lookup := range []string{MattermostUser, MattermostEmail, MattermostUserID} {
+		if lookup != "" {
+			userLookups++
+		}
+	}
+	findHeavyUsers := FindHeavyUsers > 0
+	if userLookups == 0 && !AllUsersFlag && !StdinUsersFlag && !TeamAllFlag && !findHeavyUsers {
+		LogMessage(errorLevel, "A Mattermost username, email address or user ID is required to use this utility.")
+		cliErrors = true
+	}
+	if userLookups > 1 {
+		LogMessage(errorLevel, "Only one of the -user, -email and -user-id flags can be used")
+		cliErrors = true
+	}
+	if userLookups > 0 && (AllUsersFlag || StdinUsersFlag || TeamAllFlag || findHeavyUsers) {
+		LogMessage(errorLevel, "The -all-users, -stdin-users, -team-all and -find-heavy-users flags cannot be combined with a specific user")
+		cliErrors = true
+	}
+	multiUserModes := 0
+	for _, mode := range []bool{AllUsersFlag, StdinUsersFlag, TeamAllFlag, findHeavyUsers} {
+		if mode {
+			multiUserModes++
+		}
+	}
+	if multiUserModes > 1 {
+		LogMessage(errorLevel, "Only one of the -all-users, -stdin-users, -team-all and -find-heavy-users flags can be used")
+		cliErrors = true
+	}
+
+	if TeamName != "" && TeamID != "" {
+		LogMessage(errorLevel, "Only one of the -team and -team-id flags can be used")
+		cliErrors = true
+	}
+
+	if Concurrency < 1 {
+		LogMessage(errorLevel, "The concurrency must be at least 1")
+		cliErrors = true
+	}
+
+	channelTypes, err := parseChannelTypes(ChannelTypeList)
+	if err != nil {
+		LogMessage(errorLevel, "The channel type list is invalid: "+err.Error())
+		cliErrors = true
+	}
+
+	excludedChannelTypes, err := parseChannelTypes(ExcludeChannelTypeList)
+	if err != nil {
+		LogMessage(errorLevel, "The excluded channel type list is invalid: "+err.Error())
+		cliErrors = true
+	}
+
+	if ChannelTypeList != "" && ExcludeChannelTypeList != "" {
+		LogMessage(errorLevel, "The -channel-type and -exclude-channel-type flags cannot be used together")
+		cliErrors = true
+	}
+
+	if NoSystemChannelsFlag && OnlySystemChannelsFlag {
+		LogMessage(errorLevel, "The -no-system-channels and -only-system-channels flags cannot be used together")
+		cliErrors = true
+	}
+
+	Role = strings.ToLower(Role)
+	if Role != "" && Role != "admin" && Role != "member" && Role != "guest" {
+		LogMessage(errorLevel, "The role must be one of admin, member or guest")
+		cliErrors = true
+	}
+
+	Format = strings.ToLower(Format)
+	if !slices.Contains([]string{formatText, formatBarChart, formatCSV, formatTSV, formatJSON, formatXML, formatNDJSON, formatHTML, formatDOT}, Format) {
+		LogMessage(errorLevel, "The output format must be one of text, bar-chart, csv, tsv, json, xml, ndjson, html or dot")
+		cliErrors = true
+	}
+	// The instance-wide team summary has no user to report on, so only the tabular formats apply
+	if TeamAllFlag && !slices.Contains([]string{formatText, formatCSV, formatTSV, formatJSON, formatNDJSON}, Format) {
+		LogMessage(errorLevel, "The -team-all output format must be one of text, csv, tsv, json or ndjson")
+		cliErrors = true
+	}
+
+	var channelFilter *regexp.Regexp
+	if ChannelFilter != "" {
+		channelFilter, err = regexp.Compile(ChannelFilter)
+		if err != nil {
+			LogMessage(errorLevel, "The channel filter is not a valid regular expression: "+err.Error())
+			cliErrors = true
+		}
+	}
+
+	sinceDate, err := parseSince(Since)
+	if err != nil {
+		LogMessage(errorLevel, "The since date is invalid: "+err.Error())
+		cliErrors = true
+	}
+
+	// When both -since and -since-days are given, the more recent date takes precedence
+	if SinceDays < 0 {
+		LogMessage(errorLevel, "The number of since days cannot be negative")
+		cliErrors = true
+	} else if SinceDays > 0 {
+		if cutoff := time.Now().AddDate(0, 0, -SinceDays); cutoff.After(sinceDate) {
+			sinceDate = cutoff
+		}
+	}
+
+	if RetryDelay < 0 {
+		LogMessage(errorLevel, "The retry delay cannot be negative")
+		cliErrors = true
+	}
+
+	if RateLimitSleep < 0 {
+		LogMessage(errorLevel, "The rate limit sleep cannot be negative")
+		cliErrors = true
+	}
+
+	var staleBefore time.Time
+	if StaleDays < 0 {
+		LogMessage(errorLevel, "The number of stale days cannot be negative")
+		cliErrors = true
+	} else if StaleDays > 0 {
+		staleBefore = time.Now().AddDate(0, 0, -StaleDays)
+	}
+
+	if FindHeavyUsers < 0 {
+		LogMessage(errorLevel, "The -find-heavy-users channel count cannot be negative")
+		cliErrors = true
+	}
+
+	if InactiveDMDays < 0 {
+		LogMessage(errorLevel, "The number of inactive DM days cannot be negative")
+		cliErrors = true
+	}
+
+	if TrendFlag && SaveDir == "" {
+		LogMessage(errorLevel, "The -trend flag requires a directory of saved runs, supplied with -save")
+		cliErrors = true
+	}
+
+	if PartialResultsOKFlag && !GracefulDegradationFlag {
+		LogMessage(errorLevel, "The -partial-results-ok flag can only be used with -graceful-degradation")
+		cliErrors = true
+	}
+
+	if ChannelCountDeltaFlag && SaveDir == "" {
+		LogMessage(errorLevel, "The -channel-count-delta flag requires a directory of saved runs, supplied with -save")
+		cliErrors = true
+	}
+
+	if SkipIfUnchangedFlag && SaveDir == "" {
+		LogMessage(errorLevel, "The -skip-if-unchanged flag requires a directory of saved runs, supplied with -save")
+		cliErrors = true
+	}
+
+	if ColorFlag && NoColorFlag {
+		LogMessage(errorLevel, "The -color and -no-color flags cannot be used together")
+		cliErrors = true
+	}
+
+	if cliErrors {
+		flag.Usage()
+		os.Exit(ExitBadArgs)
+	}
+
+	debugMode = DebugFlag
+	colorEnabled = detectColor(ColorFlag, NoColorFlag)
+	progressEnabled = ProgressFlag && isTerminal(os.Stderr)
+	retryDelay = RetryDelay
+	logToStderr = Format == formatCSV || Format == formatTSV || Format == formatJSON || Format == formatXML || Format == formatNDJSON || Format == formatHTML || Format == formatDOT || ChannelCountOnlyFlag
+
+	// Prepare the Mattermost connection
+	mattermostConenction := mmConnection{
+		mmURL:    MattermostURL,
+		mmPort:   MattermostPort,
+		mmScheme: MattermostScheme,
+		mmToken:  MattermostToken,
+	}
+
+	// Omitting the port leaves the scheme's standard port to be used, e.g. behind a reverse proxy
+	if NoPortFlag {
+		mattermostConenction.mmPort = ""
+	}
+
+	mmClient, err := NewMMClient(mattermostConenction)
+	if err != nil {
+		LogMessage(errorLevel, "Failed to create the Mattermost client: "+err.Error())
+		os.Exit(ExitBadArgs)
+	}
+	DebugPrint("Connected to Mattermost")
+
+	LogMessage(infoLevel, "Processing started - Version: "+Version)
+
+	// The profiles are written by exit handlers, as deferred calls don't run when exiting with os.Exit.  The handlers
+	// run in reverse order, so the heap profile is registered first to be written immediately before exiting.
+	if MemProfilePath != "" {
+		atExit(func() {
+			if err := writeHeapProfile(MemProfilePath); err != nil {
+				LogMessage(warningLevel, "Failed to write the heap profile: "+err.Error())
+			}
+		})
+	}
+	if ProfilePath != "" {
+		stopProfile, err := startCPUProfile(ProfilePath)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to start the CPU profile: "+err.Error())
+			exit(ExitOutputError)
+		}
+		atExit(stopProfile)
+	}
+
+	// Cancel any in-flight requests cleanly if the user interrupts the run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	atExit(stop)
+
+	if err := ValidateConnection(ctx, *mmClient); err != nil {
+		LogMessage(errorLevel, err.Error())
+		exit(ExitConnectionFailed)
+	}
+
+	if VersionCheckFlag {
+		if err := CheckServerVersion(ctx, *mmClient); err != nil {
+			LogMessage(warningLevel, "Unable to check the Mattermost server version: "+err.Error())
+		}
+	}
+
+	// A token that has been revoked, or belongs to a deactivated account, would otherwise fail as "user not found"
+	if MattermostToken != "" {
+		if err := HealthCheck(ctx, *mmClient); err != nil {
+			LogMessage(errorLevel, "The access token can't be used: "+err.Error())
+			var tokenErr *TokenExpiredError
+			var deactivatedErr *DeactivatedUserError
+			if errors.As(err, &tokenErr) || errors.As(err, &deactivatedErr) {
+				exit(ExitTokenRejected)
+			}
+			exit(ExitConnectionFailed)
+		}
+	}
+
+	// Without a token, exchange the username and password for a session token, which is invalidated on exit
+	if MattermostToken == "" {
+		DebugPrint("Logging in as " + LoginUsername)
+		if _, _, err := mmClient.Login(ctx, LoginUsername, LoginPassword); err != nil {
+			LogMessage(errorLevel, "Failed to log in to Mattermost: "+err.Error())
+			exit(ExitLoginFailed)
+		}
+		atExit(func() {
+			DebugPrint("Logging out of Mattermost")
+			if _, err := mmClient.Logout(context.Background()); err != nil {
+				LogMessage(warningLevel, "Failed to log out of Mattermost: "+err.Error())
+			}
+		})
+	}
+
+	options := countOptions{
+		channelTypes: channelTypes,
+		countUnread:  CountUnreadFlag,
+		memberStats:  MemberStatsFlag,
+		since:        sinceDate,
+
+		excludedChannelTypes: excludedChannelTypes,
+
+		excludeSystemChannels: NoSystemChannelsFlag,
+		onlySystemChannels:    OnlySystemChannelsFlag,
+		role:                  Role,
+		listChannels:          ListChannelsFlag,
+		channelFilter:         channelFilter,
+		noDMs:                 NoDMFlag,
+		guestSafe:             GuestSafeFlag,
+		includeArchived:       IncludeArchivedFlag,
+		purposeFilter:         strings.ToLower(PurposeFilter),
+		teamRole:              TeamRoleFlag,
+		teamMemberCount:       TeamMemberCountFlag,
+		teamSummary:           TeamSummaryFlag,
+		remoteOnly:            RemoteOnlyFlag,
+		countMuted:            MutedChannelsFlag,
+		countFavourites:       FavouriteChannelsFlag,
+		sidebarCategories:     SidebarCategoriesFlag,
+		staleBefore:           staleBefore,
+		countPinned:           CountPinnedFlag,
+		gracefulDegradation:   GracefulDegradationFlag,
+		rateLimitSleep:        RateLimitSleep,
+	}
+
+	// The channel statistics need the details of every channel, including its member count
+	if ChannelStatsCSV != "" {
+		options.listChannels = true
+		options.memberStats = true
+	}
+	if ChannelPurposeCSV != "" {
+		options.listChannels = true
+	}
+
+	// NDJSON is streamed as each team is counted, rather than being written once everything is complete
+	if Format == formatNDJSON {
+		options.teamCounted = func(user User, team Team) {
+			if SuppressZeroTeamsFlag && isZeroTeam(team) {
+				return
+			}
+			if err := PrintNDJSONRecord(user, team); err != nil {
+				LogMessage(errorLevel, "Failed to write NDJSON output: "+err.Error())
+			}
+		}
+	}
+
+	displayOptions := summaryOptions{
+		showPercent:     ShowPercentFlag,
+		showUnread:      CountUnreadFlag,
+		showMemberStats: MemberStatsFlag,
+		showStats:       StatsFlag,
+		listChannels:    ListChannelsFlag,
+		verbose:         VerboseFlag,
+		nameWidth:       NameWidth,
+		noHeader:        NoHeaderFlag,
+		hideDMs:         NoDMFlag,
+
+		showSystemChannelsExcluded: NoSystemChannelsFlag,
+		showArchived:               IncludeArchivedFlag,
+		showTeamRole:               TeamRoleFlag,
+		showTeamMemberCount:        TeamMemberCountFlag,
+		showTeamSummary:            TeamSummaryFlag,
+		channelTypes:               channelTypes,
+		excludedChannelTypes:       excludedChannelTypes,
+		showShared:                 SharedChannelsFlag,
+		showMuted:                  MutedChannelsFlag,
+		showFavourites:             FavouriteChannelsFlag,
+		showSidebarCategories:      SidebarCategoriesFlag,
+		showPinned:                 CountPinnedFlag,
+		inactiveDMDays:             InactiveDMDays,
+	}
+
+	if TeamAllFlag {
+		teams, err := ProcessAllTeams(ctx, *mmClient, ExcludeTeams)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve teams from Mattermost")
+			exit(ExitTeamsError)
+		}
+		if err := PrintTeamsSummary(teams, Format, displayOptions); err != nil {
+			LogMessage(errorLevel, "Failed to write output: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if AllUsersFlag {
+		reports, err := ProcessAllUsers(ctx, *mmClient, ExcludeTeams, options, Concurrency)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve users from Mattermost")
+			exit(ExitUserNotFound)
+		}
+		if err := PrintUsersSummary(reports, Format, displayOptions); err != nil {
+			LogMessage(errorLevel, "Failed to write output: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if findHeavyUsers {
+		users, err := ListUsersWithHighChannelCount(ctx, *mmClient, FindHeavyUsers)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve users from Mattermost")
+			exit(ExitUserNotFound)
+		}
+		if err := PrintHeavyUsers(users, FindHeavyUsers, Format, displayOptions); err != nil {
+			LogMessage(errorLevel, "Failed to write output: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if StdinUsersFlag {
+		usernames, err := ReadUsernames(os.Stdin)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to read usernames from stdin: "+err.Error())
+			exit(ExitBadArgs)
+		}
+		reports, err := ProcessUsers(ctx, *mmClient, GetUsersByUsername(ctx, *mmClient, usernames), ExcludeTeams, options, Concurrency)
+		if err != nil {
+			LogMessage(errorLevel, "Processing cancelled")
+			exit(ExitCancelled)
+		}
+		if err := PrintUsersSummary(reports, Format, displayOptions); err != nil {
+			LogMessage(errorLevel, "Failed to write output: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	// Get the ID (and other information) of the user
+	var user *User
+	progress := startSpinner("Retrieving user")
+	if MattermostUserID != "" {
+		user, err = GetUserFromID(ctx, *mmClient, MattermostUserID)
+	} else if MattermostEmail != "" {
+		user, err = GetUserIDFromEmail(ctx, *mmClient, MattermostEmail)
+	} else {
+		user, err = GetUserIDFromUsername(ctx, *mmClient, MattermostUser)
+	}
+	progress.Stop()
+	if err != nil {
+		LogMessage(errorLevel, "Failed to retrieve user from Mattermost")
+		exit(ExitUserNotFound)
+	}
+
+	if UserInfoOnlyFlag {
+		if err := PrintUserInfo(*user, Format == formatJSON); err != nil {
+			LogMessage(errorLevel, "Failed to write user details: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if DMOnlyFlag {
+		dmChannels, err := GetDirectMessageChannels(ctx, *mmClient, user.ID)
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve direct message channels from Mattermost")
+			exit(ExitChannelsError)
+		}
+		dmChannelCount, groupChannelCount := CountDirectMessageChannels(selectDirectMessageChannels(dmChannels, options))
+		if err := PrintDMSummary(Report{User: *user, DMChannelCount: dmChannelCount, GroupChannelCount: groupChannelCount}, Format == formatJSON); err != nil {
+			LogMessage(errorLevel, "Failed to write direct message summary: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if user.Deactivated {
+		if DeactivatedFlag {
+			LogMessage(warningLevel, "User "+user.Username+" is deactivated - reporting their last-known teams and channels")
+		} else {
+			LogMessage(warningLevel, "User "+user.Username+" is deactivated - use -deactivated to handle deactivated accounts explicitly")
+		}
+	}
+
+	// Get the teams that this user is a member of
+	progress = startSpinner("Retrieving teams")
+	teams, err := GetTeamsForUser(ctx, *mmClient, user.ID)
+	progress.Stop()
 	if err != nil {
-		LogMessage(errorLevel, "Failed to retrieve teams from Mattermost")
-		os.Exit(11)
+		// Team memberships may no longer be retrievable for deactivated accounts, so report what we can
+		if !(user.Deactivated && DeactivatedFlag) {
+			LogMessage(errorLevel, "Failed to retrieve teams from Mattermost")
+			exit(ExitTeamsError)
+		}
+		LogMessage(warningLevel, "Failed to retrieve teams for deactivated user "+user.Username)
+	}
+
+	displayOptions.memberTeamCount = len(teams)
+
+	// The policy limit applies to the user's actual team membership, regardless of which teams are being reported on
+	if MaxTeams > 0 && len(teams) > MaxTeams {
+		LogMessage(warningLevel, fmt.Sprintf("User %s is a member of %d teams, which exceeds the limit of %d", user.Username, len(teams), MaxTeams))
+		exit(ExitWarning)
+	}
+
+	// Drop any teams that have been explicitly excluded on the command line
+	teams = filterExcludedTeams(teams, ExcludeTeams)
+
+	if TeamName != "" || TeamID != "" {
+		teams = selectTeam(teams, TeamName, TeamID)
+		if len(teams) == 0 {
+			LogMessage(errorLevel, "User "+user.Username+" is not a member of team "+valueOrDefault(TeamName, TeamID))
+			exit(ExitTeamsError)
+		}
 	}
 
 	user.Teams = teams
-	var totalDMChannels, totalGroupChannels int
-	firstTeam := true
-
-	for i := range teams {
-		var teamChannelCount, dmChannelCount, groupChannelCount int
-
-		// We only need to count the DMs for the first team, as they'll be common across all teams
-		// for a given user and Mattermost connection.
-		if firstTeam {
-			teamChannelCount, dmChannelCount, groupChannelCount, err = GetChannelCountForTeam(*mmClient, teams[i].ID, user.ID, true)
-			totalDMChannels = dmChannelCount
-			totalGroupChannels = groupChannelCount
-			firstTeam = false
+
+	if TeamsOnlyFlag {
+		if err := PrintTeams(*user, Format == formatJSON); err != nil {
+			LogMessage(errorLevel, "Failed to write teams: "+err.Error())
+			exit(ExitOutputError)
+		}
+		exit(ExitOK)
+	}
+
+	if ShowInstanceTeamsFlag {
+		allTeams, err := GetAllTeams(ctx, *mmClient)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to retrieve the total number of teams on the instance")
 		} else {
-			teamChannelCount, _, _, err = GetChannelCountForTeam(*mmClient, teams[i].ID, user.ID, false)
+			displayOptions.instanceTeamCount = len(allTeams)
+		}
+	}
+
+	progress = startSpinner("Counting channels")
+	teamErrors := CountChannelsForTeams(ctx, *mmClient, teams, *user, options, Concurrency)
+	// The DMs are retrieved once, and shared by the count, the partner list and the inactivity check
+	var dmChannels []*model.Channel
+	var totalDMChannels, totalGroupChannels int
+	var dmErr error
+	if !options.noDMs {
+		dmChannels, dmErr = GetDirectMessageChannels(ctx, *mmClient, user.ID)
+		dmChannels = selectDirectMessageChannels(dmChannels, options)
+		totalDMChannels, totalGroupChannels = CountDirectMessageChannels(dmChannels)
+	}
+	progress.Stop()
+	if ctx.Err() != nil {
+		LogMessage(errorLevel, "Processing cancelled")
+		exit(ExitCancelled)
+	}
+	if len(teamErrors) >= maxErrors && !GracefulDegradationFlag {
+		LogMessage(errorLevel, fmt.Sprintf("Failed to get channel counts for %d teams: %v", len(teamErrors), errors.Join(teamErrors...)))
+		exit(ExitChannelsError)
+	}
+	// Direct messages aren't part of any team, so a failure to count them is reported on its own
+	if dmErr != nil {
+		if !GracefulDegradationFlag {
+			LogMessage(errorLevel, "Failed to count direct message channels: "+dmErr.Error())
+			exit(ExitChannelsError)
+		}
+		LogMessage(warningLevel, "Failed to count direct message channels for "+user.Username+" - they are left out of the total")
+		totalDMChannels, totalGroupChannels = 0, 0
+		displayOptions.dmChannelsFailed = true
+	}
+
+	// When auditing the system channels, flag any team that appears to be missing one of them
+	if OnlySystemChannelsFlag {
+		for _, team := range teams {
+			if team.ChannelCount < expectedSystemChannels {
+				LogMessage(warningLevel, fmt.Sprintf("Team %s has %d of the %d expected system channels", team.Name, team.ChannelCount, expectedSystemChannels))
+			}
+		}
+	}
+
+	if ShowDMPartnersFlag && !NoDMFlag && dmErr == nil {
+		user.DMPartners, err = GetDMPartners(ctx, *mmClient, dmChannels, user.ID)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to retrieve the user's direct message partners")
+		}
+	}
+
+	if InactiveDMDays > 0 && !NoDMFlag && dmErr == nil {
+		user.InactiveDMCount = CountInactiveDMChannels(dmChannels, InactiveDMDays)
+	}
+
+	user.TotalChannelCount = sumChannelCounts(user.Teams, totalDMChannels)
+
+	if SuppressZeroTeamsFlag {
+		user.Teams, displayOptions.suppressedTeams = suppressZeroTeams(user.Teams)
+	}
+
+	// Exceeding the hard limit is a policy violation, so there's no point reporting the details
+	if MaxChannelCount > 0 && user.TotalChannelCount > MaxChannelCount {
+		LogMessage(errorLevel, fmt.Sprintf("User %s has %d channels, which exceeds the maximum of %d", user.Username, user.TotalChannelCount, MaxChannelCount))
+		exit(ExitThresholdError)
+	}
+
+	report := Report{
+		User:              *user,
+		DMChannelCount:    totalDMChannels,
+		GroupChannelCount: totalGroupChannels,
+
+		SuppressedTeamCount: displayOptions.suppressedTeams,
+	}
+	if dmErr != nil {
+		report.DMError = dmErr.Error()
+	}
+
+	var previous Report
+	previousFound := false
+	if SkipIfUnchangedFlag || ChannelCountDeltaFlag {
+		previous, previousFound, err = LoadLatestReport(SaveDir, user.Username)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to load the last saved run: "+err.Error())
+		}
+	}
+
+	// Saving an identical report would only add a duplicate entry to the history
+	if SkipIfUnchangedFlag && previousFound && countsUnchanged(previous, report) {
+		LogMessage(infoLevel, "No changes detected")
+		exit(ExitOK)
+	}
+
+	displayOptions.showChannelCountDelta = ChannelCountDeltaFlag
+	if ChannelCountDeltaFlag && previousFound {
+		// Reports saved by older versions don't include the total, so it's recalculated
+		delta := report.TotalChannelCount - sumChannelCounts(previous.Teams, previous.DMChannelCount)
+		displayOptions.channelCountDelta = &delta
+	}
+
+	// The trend ends with the current run, which will only be saved once the output has been written
+	if TrendFlag {
+		report.Trend, err = LoadTrend(SaveDir, user.Username)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			LogMessage(warningLevel, "Failed to load the saved runs for the trend: "+err.Error())
+		}
+		report.Trend = append(report.Trend, TrendPoint{Timestamp: time.Now(), TotalChannelCount: report.TotalChannelCount})
+	}
+
+	switch {
+	case ChannelCountOnlyFlag:
+		fmt.Println(report.TotalChannelCount)
+	case Format == formatBarChart:
+		PrintBarChart(*user, Width)
+	case Format == formatCSV:
+		if err := PrintCSV(*user, displayOptions); err != nil {
+			LogMessage(errorLevel, "Failed to write CSV output: "+err.Error())
+			exit(ExitOutputError)
 		}
+	case Format == formatTSV:
+		PrintTSV(*user, displayOptions)
+	case Format == formatJSON:
+		if err := PrintJSON(report); err != nil {
+			LogMessage(errorLevel, "Failed to write JSON output: "+err.Error())
+			exit(ExitOutputError)
+		}
+	case Format == formatNDJSON:
+		// Each team has already been written as it was counted
+	case Format == formatHTML:
+		if err := PrintHTML(report); err != nil {
+			LogMessage(errorLevel, "Failed to write HTML output: "+err.Error())
+			exit(ExitOutputError)
+		}
+	case Format == formatDOT:
+		if err := PrintDOT(report); err != nil {
+			LogMessage(errorLevel, "Failed to write DOT output: "+err.Error())
+			exit(ExitOutputError)
+		}
+	case Format == formatXML:
+		if err := PrintXML(report); err != nil {
+			LogMessage(errorLevel, "Failed to write XML output: "+err.Error())
+			exit(ExitOutputError)
+		}
+	default:
+		PrintSummary(*user, totalDMChannels, totalGroupChannels, displayOptions)
+	}
+
+	if SaveDir != "" {
+		path, err := SaveReport(SaveDir, report)
 		if err != nil {
-			LogMessage(warningLevel, "Failed to get channel count for team "+teams[i].Name)
+			LogMessage(errorLevel, "Failed to save report: "+err.Error())
+			exit(ExitOutputError)
+		}
+		LogMessage(infoLevel, "Report saved to "+path)
+	}
+
+	if ChannelStatsCSV != "" {
+		if err := WriteChannelStatsCSV(ChannelStatsCSV, *user); err != nil {
+			LogMessage(errorLevel, "Failed to write channel statistics: "+err.Error())
+			exit(ExitOutputError)
+		}
+		LogMessage(infoLevel, "Channel statistics written to "+ChannelStatsCSV)
+	}
+
+	if ChannelPurposeCSV != "" {
+		if err := WriteChannelPurposeCSV(ChannelPurposeCSV, *user); err != nil {
+			LogMessage(errorLevel, "Failed to write channel purposes: "+err.Error())
+			exit(ExitOutputError)
+		}
+		LogMessage(infoLevel, "Channel purposes written to "+ChannelPurposeCSV)
+	}
+
+	if WebhookURL != "" {
+		if err := PostWebhook(ctx, WebhookURL, webhookPayload{Text: BuildMarkdownSummary(report)}); err != nil {
+			LogMessage(errorLevel, "Failed to post to webhook: "+err.Error())
+			exit(ExitWebhookError)
+		}
+		LogMessage(infoLevel, "Summary posted to webhook")
+	}
+
+	exitCode := ExitOK
+
+	severity, threshold := checkThresholds(report.TotalChannelCount, ThresholdWarn, ThresholdError)
+	if severity != "" {
+		message := fmt.Sprintf("User %s has %d channels, which exceeds the %s threshold of %d", user.Username, report.TotalChannelCount, strings.ToLower(string(severity)), threshold)
+		LogMessage(severity, message)
+
+		if AlertWebhookURL != "" {
+			alert := alertPayload{
+				Text:         ":warning: " + message,
+				Severity:     strings.ToLower(string(severity)),
+				Threshold:    threshold,
+				ChannelCount: report.TotalChannelCount,
+			}
+			if err := PostWebhook(ctx, AlertWebhookURL, alert); err != nil {
+				LogMessage(errorLevel, "Failed to post to alert webhook: "+err.Error())
+				exit(ExitWebhookError)
+			}
+			LogMessage(infoLevel, "Alert posted to webhook")
+		}
+
+		exitCode = ExitWarning
+		if severity == errorLevel {
+			exitCode = ExitThresholdError
 		}
-		teams[i].ChannelCount = teamChannelCount
 	}
 
-	PrintSummary(*user, totalDMChannels, totalGroupChannels)
+	// The failed teams and direct messages have been flagged in the output, but the counts are still incomplete
+	if GracefulDegradationFlag && (len(teamErrors) > 0 || dmErr != nil) {
+		// Teams skipped as access denied haven't been counted either, so they can't make up the partial results
+		uncountedTeams := len(teamErrors)
+		for _, team := range teams {
+			if team.AccessDenied {
+				uncountedTeams++
+			}
+		}
+		if PartialResultsOKFlag && uncountedTeams < len(teams) {
+			if len(teamErrors) > 0 {
+				LogMessage(warningLevel, fmt.Sprintf("Reporting partial results, as %d of %d teams could not be counted", uncountedTeams, len(teams)))
+			}
+		} else {
+			exitCode = ExitChannelsError
+		}
+	}
+
+	if user.Deactivated && DeactivatedFlag {
+		exitCode = ExitDeactivated
+	}
+	exit(exitCode)
 }
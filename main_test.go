@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestChannelSelected(t *testing.T) {
+	now := time.Now()
+	publicChannel := &model.Channel{Id: "public", Type: model.ChannelTypeOpen, DisplayName: "Engineering", Purpose: "Builds", CreateAt: now.UnixMilli(), LastPostAt: now.UnixMilli()}
+	directChannel := &model.Channel{Id: "direct", Type: model.ChannelTypeDirect, CreateAt: now.UnixMilli(), LastPostAt: now.UnixMilli()}
+	groupChannel := &model.Channel{Id: "group", Type: model.ChannelTypeGroup, CreateAt: now.AddDate(0, 0, -30).UnixMilli()}
+	members := map[string]model.ChannelMember{"public": {Roles: model.ChannelUserRoleId}}
+
+	tests := []struct {
+		name    string
+		channel *model.Channel
+		options countOptions
+		want    bool
+	}{
+		{name: "no filters", channel: publicChannel, want: true},
+		{name: "admin role doesn't match", channel: publicChannel, options: countOptions{role: "admin"}, want: false},
+		{name: "member role (channel_user) matches", channel: publicChannel, options: countOptions{role: "member"}, want: true},
+		{name: "name filter doesn't match", channel: publicChannel, options: countOptions{channelFilter: regexp.MustCompile("^Sales")}, want: false},
+		{name: "purpose filter matches", channel: publicChannel, options: countOptions{purposeFilter: "build"}, want: true},
+		{name: "created before since", channel: groupChannel, options: countOptions{since: now.AddDate(0, 0, -7)}, want: false},
+		{name: "recently posted in isn't stale", channel: publicChannel, options: countOptions{staleBefore: now.AddDate(0, 0, -7)}, want: false},
+		{name: "never posted in is stale", channel: groupChannel, options: countOptions{staleBefore: now.AddDate(0, 0, -7)}, want: true},
+		{name: "only system channels", channel: publicChannel, options: countOptions{onlySystemChannels: true}, want: false},
+		{name: "not a remote channel", channel: publicChannel, options: countOptions{remoteOnly: true}, want: false},
+		{name: "DM survives member role (channel_user)", channel: directChannel, options: countOptions{role: "member"}, want: true},
+		{name: "DM survives admin role", channel: directChannel, options: countOptions{role: "admin"}, want: true},
+		{name: "DM survives name filter", channel: directChannel, options: countOptions{channelFilter: regexp.MustCompile("^Sales")}, want: true},
+		{name: "GM survives purpose filter", channel: groupChannel, options: countOptions{purposeFilter: "build"}, want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The members are only loaded for the team channels, so the DMs and GMs never have one
+			if got := channelSelected(test.channel, test.options, members, nil); got != test.want {
+				t.Errorf("channelSelected() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectDirectMessageChannels(t *testing.T) {
+	now := time.Now()
+	channels := []*model.Channel{
+		{Id: "recent", Type: model.ChannelTypeDirect, CreateAt: now.UnixMilli()},
+		{Id: "old", Type: model.ChannelTypeDirect, CreateAt: now.AddDate(0, -6, 0).UnixMilli()},
+		{Id: "group", Type: model.ChannelTypeGroup, CreateAt: now.UnixMilli()},
+		{Id: "archived", Type: model.ChannelTypeGroup, CreateAt: now.UnixMilli(), DeleteAt: now.UnixMilli()},
+	}
+
+	tests := []struct {
+		name    string
+		options countOptions
+		want    []string
+	}{
+		{name: "no filters", want: []string{"recent", "old", "group"}},
+		{name: "including archived", options: countOptions{includeArchived: true}, want: []string{"recent", "old", "group", "archived"}},
+		{name: "group messages only", options: countOptions{channelTypes: map[model.ChannelType]bool{model.ChannelTypeGroup: true}}, want: []string{"group"}},
+		{name: "direct messages excluded", options: countOptions{excludedChannelTypes: map[model.ChannelType]bool{model.ChannelTypeDirect: true}}, want: []string{"group"}},
+		{name: "since", options: countOptions{since: now.AddDate(0, -1, 0)}, want: []string{"recent", "group"}},
+		{name: "role doesn't apply", options: countOptions{role: "member"}, want: []string{"recent", "old", "group"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, channel := range selectDirectMessageChannels(channels, test.options) {
+				got = append(got, channel.Id)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("selectDirectMessageChannels() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountInactiveDMChannels(t *testing.T) {
+	now := time.Now()
+	channels := []*model.Channel{
+		{Type: model.ChannelTypeDirect, LastPostAt: now.UnixMilli()},
+		{Type: model.ChannelTypeDirect, LastPostAt: now.AddDate(0, 0, -45).UnixMilli()},
+		{Type: model.ChannelTypeGroup, LastPostAt: now.AddDate(0, 0, -10).UnixMilli()},
+		{Type: model.ChannelTypeGroup},
+	}
+
+	tests := []struct {
+		days int
+		want int
+	}{
+		{days: 1, want: 3},
+		{days: 30, want: 2},
+		{days: 90, want: 1},
+	}
+
+	for _, test := range tests {
+		if got := CountInactiveDMChannels(channels, test.days); got != test.want {
+			t.Errorf("CountInactiveDMChannels(%d days) = %d, want %d", test.days, got, test.want)
+		}
+	}
+}
+
+func TestStripURLScheme(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantURL    string
+		wantScheme string
+	}{
+		{url: "mattermost.example.com", wantURL: "mattermost.example.com"},
+		{url: "https://mattermost.example.com", wantURL: "mattermost.example.com", wantScheme: "https"},
+		{url: "http://mattermost.example.com", wantURL: "mattermost.example.com", wantScheme: "http"},
+		{url: "HTTPS://Mattermost.example.com", wantURL: "Mattermost.example.com", wantScheme: "https"},
+		{url: "ftp://mattermost.example.com", wantURL: "ftp://mattermost.example.com"},
+		{url: "http:/", wantURL: "http:/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			gotURL, gotScheme := stripURLScheme(test.url)
+			if gotURL != test.wantURL || gotScheme != test.wantScheme {
+				t.Errorf("stripURLScheme(%q) = %q, %q, want %q, %q", test.url, gotURL, gotScheme, test.wantURL, test.wantScheme)
+			}
+		})
+	}
+}
+
+func TestParseChannelTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[model.ChannelType]bool
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[model.ChannelType]bool{},
+		},
+		{
+			name:  "single type",
+			value: "O",
+			want:  map[model.ChannelType]bool{model.ChannelTypeOpen: true},
+		},
+		{
+			name:  "lower case with spaces",
+			value: "o, p ,d,g",
+			want: map[model.ChannelType]bool{
+				model.ChannelTypeOpen:    true,
+				model.ChannelTypePrivate: true,
+				model.ChannelTypeDirect:  true,
+				model.ChannelTypeGroup:   true,
+			},
+		},
+		{
+			name:    "invalid type",
+			value:   "O,X",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseChannelTypes(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseChannelTypes(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			}
+			if !test.wantErr && !maps.Equal(got, test.want) {
+				t.Errorf("parseChannelTypes(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "empty disables filtering",
+			value: "",
+			want:  time.Time{},
+		},
+		{
+			name:  "RFC 3339",
+			value: "2024-04-01T12:30:00Z",
+			want:  time.Date(2024, 4, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:  "date only is local midnight",
+			value: "2024-04-01",
+			want:  time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:    "invalid",
+			value:   "01/04/2024",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseSince(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseSince(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("parseSince(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetChannelCountByType(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels []*model.Channel
+		want     map[string]int
+	}{
+		{
+			name: "no channels",
+			want: map[string]int{},
+		},
+		{
+			name: "mixed types",
+			channels: []*model.Channel{
+				{Type: model.ChannelTypeOpen},
+				{Type: model.ChannelTypeOpen},
+				{Type: model.ChannelTypePrivate},
+				{Type: model.ChannelTypeDirect},
+				{Type: model.ChannelTypeGroup},
+				{Type: model.ChannelTypeGroup},
+			},
+			want: map[string]int{"O": 2, "P": 1, "D": 1, "G": 2},
+		},
+		{
+			name:     "types without channels are left out",
+			channels: []*model.Channel{{Type: model.ChannelTypePrivate}},
+			want:     map[string]int{"P": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := GetChannelCountByType(test.channels)
+			if !maps.Equal(got, test.want) {
+				t.Errorf("GetChannelCountByType() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsExcludedTeam(t *testing.T) {
+	team := Team{Name: "Engineering", ID: "abc123"}
+
+	tests := []struct {
+		name       string
+		exclusions []string
+		want       bool
+	}{
+		{name: "no exclusions", want: false},
+		{name: "display name", exclusions: []string{"Engineering"}, want: true},
+		{name: "display name in another case", exclusions: []string{"engineering"}, want: true},
+		{name: "ID", exclusions: []string{"Sales", "ABC123"}, want: true},
+		{name: "no match", exclusions: []string{"Sales", "Engineer"}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isExcludedTeam(team, test.exclusions); got != test.want {
+				t.Errorf("isExcludedTeam(%v) = %v, want %v", test.exclusions, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterExcludedTeams(t *testing.T) {
+	teams := []Team{{Name: "Engineering", ID: "a"}, {Name: "Sales", ID: "b"}, {Name: "Support", ID: "c"}}
+
+	got := filterExcludedTeams(teams, []string{"sales", "c"})
+	if len(got) != 1 || got[0].Name != "Engineering" {
+		t.Errorf("filterExcludedTeams() = %v, want only Engineering", got)
+	}
+}
+
+func TestSelectTeam(t *testing.T) {
+	teams := []Team{{Name: "Engineering", ID: "a1"}, {Name: "Sales", ID: "b2"}}
+
+	tests := []struct {
+		name     string
+		teamName string
+		teamID   string
+		want     string
+	}{
+		{name: "by display name", teamName: "Sales", want: "Sales"},
+		{name: "by display name in another case", teamName: "engineering", want: "Engineering"},
+		{name: "by ID", teamID: "B2", want: "Sales"},
+		{name: "not a member", teamName: "Support"},
+		{name: "nothing requested"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := selectTeam(teams, test.teamName, test.teamID)
+			if test.want == "" {
+				if len(got) != 0 {
+					t.Errorf("selectTeam() = %v, want no teams", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Name != test.want {
+				t.Errorf("selectTeam() = %v, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterChannelsByType(t *testing.T) {
+	channels := []*model.Channel{
+		{Id: "public", Type: model.ChannelTypeOpen},
+		{Id: "private", Type: model.ChannelTypePrivate},
+		{Id: "direct", Type: model.ChannelTypeDirect},
+		{Id: "group", Type: model.ChannelTypeGroup},
+	}
+
+	tests := []struct {
+		name    string
+		include map[model.ChannelType]bool
+		exclude map[model.ChannelType]bool
+		wantIDs []string
+	}{
+		{name: "all types", wantIDs: []string{"public", "private", "direct", "group"}},
+		{name: "included types", include: map[model.ChannelType]bool{model.ChannelTypeOpen: true, model.ChannelTypeGroup: true}, wantIDs: []string{"public", "group"}},
+		{name: "excluded types", exclude: map[model.ChannelType]bool{model.ChannelTypeDirect: true, model.ChannelTypeGroup: true}, wantIDs: []string{"public", "private"}},
+		{name: "both", include: map[model.ChannelType]bool{model.ChannelTypeOpen: true, model.ChannelTypePrivate: true}, exclude: map[model.ChannelType]bool{model.ChannelTypePrivate: true}, wantIDs: []string{"public"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotIDs []string
+			for _, channel := range filterChannelsByType(channels, test.include, test.exclude) {
+				gotIDs = append(gotIDs, channel.Id)
+			}
+			if !slices.Equal(gotIDs, test.wantIDs) {
+				t.Errorf("filterChannelsByType() = %v, want %v", gotIDs, test.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSuppressZeroTeams(t *testing.T) {
+	teams := []Team{
+		{Name: "Engineering", ChannelCount: 5},
+		{Name: "Empty"},
+		{Name: "Denied", AccessDenied: true},
+		{Name: "Failed", ChannelCount: -1, Error: "server error"},
+		{Name: "Also empty"},
+	}
+
+	got, suppressed := suppressZeroTeams(teams)
+	var gotNames []string
+	for _, team := range got {
+		gotNames = append(gotNames, team.Name)
+	}
+
+	// Teams that couldn't be counted are kept, so that the failure is still reported
+	wantNames := []string{"Engineering", "Denied", "Failed"}
+	if !slices.Equal(gotNames, wantNames) || suppressed != 2 {
+		t.Errorf("suppressZeroTeams() = %v, %d, want %v, 2", gotNames, suppressed, wantNames)
+	}
+}
+
+func TestDefaultPortForScheme(t *testing.T) {
+	tests := []struct {
+		scheme string
+		want   string
+	}{
+		{scheme: "https", want: "443"},
+		{scheme: "HTTPS", want: "443"},
+		{scheme: "http", want: "80"},
+		{scheme: "", want: defaultPort},
+	}
+
+	for _, test := range tests {
+		if got := defaultPortForScheme(test.scheme); got != test.want {
+			t.Errorf("defaultPortForScheme(%q) = %q, want %q", test.scheme, got, test.want)
+		}
+	}
+}
+
+// newTestServer returns a Mattermost API stub that serves the user's channels for each team, or the given status code
+// for the teams that should fail.
+func newTestServer(t *testing.T, channels map[string][]*model.Channel, failures map[string]int) *model.Client4 {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var teamID string
+		if _, err := fmt.Sscanf(r.URL.Path, "/api/v4/users/user1/teams/%s", &teamID); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		teamID = strings.TrimSuffix(teamID, "/channels")
+		if status, found := failures[teamID]; found {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"id":"api.test","message":"refused","status_code":%d}`, status)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(channels[teamID]); err != nil {
+			t.Errorf("failed to encode channels: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return model.NewAPIv4Client(server.URL)
+}
+
+func TestCountChannelsForTeams(t *testing.T) {
+	channels := map[string][]*model.Channel{
+		"team1": {
+			{Id: "c1", Type: model.ChannelTypeOpen},
+			{Id: "c2", Type: model.ChannelTypeOpen},
+			{Id: "c3", Type: model.ChannelTypePrivate},
+			{Id: "dm", Type: model.ChannelTypeDirect},
+		},
+		"team2": {{Id: "c4", Type: model.ChannelTypeOpen}},
+		"team3": {{Id: "c5", Type: model.ChannelTypePrivate}, {Id: "c6", Type: model.ChannelTypePrivate}},
+	}
+	failures := map[string]int{"broken": http.StatusBadRequest, "guest": http.StatusForbidden}
+	mmClient := newTestServer(t, channels, failures)
+	user := User{ID: "user1", Username: "test"}
+
+	newTeams := func() []Team {
+		return []Team{{Name: "One", ID: "team1"}, {Name: "Broken", ID: "broken"}, {Name: "Two", ID: "team2"}, {Name: "Guest", ID: "guest"}, {Name: "Three", ID: "team3"}}
+	}
+
+	tests := []struct {
+		name        string
+		options     countOptions
+		concurrency int
+		wantCounts  []int
+		wantErrors  int
+		wantDenied  string
+	}{
+		{name: "sequential", concurrency: 1, wantCounts: []int{3, 0, 1, 0, 2}, wantErrors: 2},
+		{name: "concurrent", concurrency: 3, wantCounts: []int{3, 0, 1, 0, 2}, wantErrors: 2},
+		{name: "more workers than teams", concurrency: 10, wantCounts: []int{3, 0, 1, 0, 2}, wantErrors: 2},
+		{name: "graceful degradation", concurrency: 2, options: countOptions{gracefulDegradation: true}, wantCounts: []int{3, -1, 1, -1, 2}, wantErrors: 2},
+		{name: "guest safe", concurrency: 2, options: countOptions{guestSafe: true, gracefulDegradation: true}, wantCounts: []int{3, -1, 1, 0, 2}, wantErrors: 1, wantDenied: "guest"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			teams := newTeams()
+			counted := 0
+			test.options.teamCounted = func(User, Team) { counted++ }
+
+			teamErrors := CountChannelsForTeams(context.Background(), *mmClient, teams, user, test.options, test.concurrency)
+
+			if len(teamErrors) != test.wantErrors {
+				t.Errorf("got %d errors, want %d: %v", len(teamErrors), test.wantErrors, teamErrors)
+			}
+			if counted != len(teams) {
+				t.Errorf("teamCounted called %d times, want %d", counted, len(teams))
+			}
+			// The results arrive in any order, but each must be recorded against its own team
+			for i, team := range teams {
+				if team.ChannelCount != test.wantCounts[i] {
+					t.Errorf("team %s has a channel count of %d, want %d", team.ID, team.ChannelCount, test.wantCounts[i])
+				}
+				if team.AccessDenied != (team.ID == test.wantDenied) {
+					t.Errorf("team %s has AccessDenied %v", team.ID, team.AccessDenied)
+				}
+				if (team.ChannelCount == -1) != (team.Error != "") {
+					t.Errorf("team %s has a channel count of %d and error %q", team.ID, team.ChannelCount, team.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestCountChannelsForTeamsWithoutTeams(t *testing.T) {
+	if teamErrors := CountChannelsForTeams(context.Background(), model.Client4{}, nil, User{}, countOptions{}, 1); teamErrors != nil {
+		t.Errorf("CountChannelsForTeams() = %v, want no errors", teamErrors)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	defaultTerminalWidth = 80
+	barCharacter         = "█"
+)
+
+// terminalWidth returns the configured output width, falling back to the width of the terminal that stdout is
+// attached to, then to the COLUMNS environment variable, and finally to a sensible default.
+func terminalWidth(configuredWidth int) int {
+	if configuredWidth > 0 {
+		return configuredWidth
+	}
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return defaultTerminalWidth
+}
+
+// PrintBarChart renders each team as a horizontal bar, scaled relative to the team with the most channels.
+func PrintBarChart(user User, width int) {
+
+	maxTeamNameLength := 0
+	maxChannelCount := 0
+	for _, team := range user.Teams {
+		if len(team.Name) > maxTeamNameLength {
+			maxTeamNameLength = len(team.Name)
+		}
+		if team.ChannelCount > maxChannelCount {
+			maxChannelCount = team.ChannelCount
+		}
+	}
+	countWidth := len(strconv.Itoa(maxChannelCount))
+
+	// Leave room for the team name, the count and the separators either side of the bar
+	maxBarWidth := terminalWidth(width) - maxTeamNameLength - countWidth - 5
+	if maxBarWidth < 1 {
+		maxBarWidth = 1
+	}
+
+	fmt.Printf("\nChannels per team for %s\n\n", user.Username)
+
+	for _, team := range user.Teams {
+		barWidth := 0
+		if maxChannelCount > 0 && team.ChannelCount > 0 {
+			barWidth = team.ChannelCount * maxBarWidth / maxChannelCount
+		}
+		fmt.Printf("%-*s | %s %*d\n", maxTeamNameLength, team.Name, strings.Repeat(barCharacter, barWidth), countWidth, team.ChannelCount)
+	}
+
+	fmt.Println()
+}
+
+// tableHeader returns the header row shared by the CSV and TSV output formats.  When channels are being listed, the
+// table has one row per channel rather than one row per team.
+func tableHeader(listChannels bool) []string {
+	header := []string{"Username", "Email", "Team", "TeamID", "ChannelCount"}
+	if listChannels {
+		header = append(header, "ChannelName", "ChannelDisplayName", "ChannelType", "MemberCount", "Purpose")
+	}
+	return header
+}
+
+// tableRows returns the rows of the CSV and TSV output formats, in the column order given by tableHeader.
+func tableRows(user User, listChannels bool) [][]string {
+	var rows [][]string
+	for _, team := range user.Teams {
+		channelCount := strconv.Itoa(team.ChannelCount)
+		if team.AccessDenied {
+			channelCount = "access denied"
+		}
+		if team.Error != "" {
+			channelCount = "error"
+		}
+		teamColumns := []string{user.Username, user.Email, team.Name, team.ID, channelCount}
+		if !listChannels {
+			rows = append(rows, teamColumns)
+			continue
+		}
+
+		// Teams without any matching channels still get a row, so it's clear they were checked
+		if len(team.Channels) == 0 {
+			rows = append(rows, append(teamColumns, "", "", "", "", ""))
+		}
+		for _, channel := range team.Channels {
+			rows = append(rows, append(slices.Clone(teamColumns), channel.Name, channel.DisplayName, channel.Type, strconv.Itoa(channel.MemberCount), channel.Purpose))
+		}
+	}
+	return rows
+}
+
+// PrintCSV writes the per-team channel counts as comma-separated values, quoted where necessary.
+func PrintCSV(user User, options summaryOptions) error {
+	writer := csv.NewWriter(os.Stdout)
+	if !options.noHeader {
+		if err := writer.Write(tableHeader(options.listChannels)); err != nil {
+			return err
+		}
+	}
+	if err := writer.WriteAll(tableRows(user, options.listChannels)); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// formatTimestamp formats a time for the CSV output, leaving it empty if the time is unset.
+func formatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.Format(time.RFC3339)
+}
+
+// writeCSVFile writes the header and rows to a new CSV file at the given path.
+func writeCSVFile(path string, header []string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// WriteChannelStatsCSV writes a CSV file with one row for each of the user's channels across all teams.
+func WriteChannelStatsCSV(path string, user User) error {
+	var rows [][]string
+	for _, team := range user.Teams {
+		for _, channel := range team.Channels {
+			rows = append(rows, []string{team.Name, channel.Name, channel.Type, strconv.Itoa(channel.MemberCount), formatTimestamp(channel.LastPostAt), formatTimestamp(channel.CreateAt)})
+		}
+	}
+	return writeCSVFile(path, []string{"Team", "ChannelName", "ChannelType", "MemberCount", "LastPostAt", "CreateAt"}, rows)
+}
+
+// WriteChannelPurposeCSV writes a CSV file with the purpose of each of the user's channels across all teams.  Channels
+// without a purpose are included, with an empty purpose.
+func WriteChannelPurposeCSV(path string, user User) error {
+	var rows [][]string
+	for _, team := range user.Teams {
+		for _, channel := range team.Channels {
+			rows = append(rows, []string{team.Name, channel.Name, channel.Purpose})
+		}
+	}
+	return writeCSVFile(path, []string{"Team", "ChannelName", "Purpose"}, rows)
+}
+
+// PrintTSV writes the per-team channel counts as tab-separated values.  There's no quoting, so any tabs or newlines
+// within a field are replaced with spaces to keep the output safe for tools such as awk, sort and column.
+func PrintTSV(user User, options summaryOptions) {
+	rows := tableRows(user, options.listChannels)
+	if !options.noHeader {
+		rows = append([][]string{tableHeader(options.listChannels)}, rows...)
+	}
+
+	sanitiser := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	for _, row := range rows {
+		for i := range row {
+			row[i] = sanitiser.Replace(row[i])
+		}
+		fmt.Println(strings.Join(row, "\t"))
+	}
+}
+
+// Report is the machine-readable form of a run, as written by the JSON output format and read back by the diff mode.
+type Report struct {
+	User
+	DMChannelCount    int
+	GroupChannelCount int
+	// DMError describes why the direct and group messages couldn't be counted, in which case both counts are 0
+	DMError string `json:",omitempty" xml:",omitempty"`
+
+	// SuppressedTeamCount is the number of teams without any channels that were left out of the report
+	SuppressedTeamCount int `json:",omitempty" xml:",omitempty"`
+
+	// Trend holds the total channel count from each of the user's saved runs, when requested
+	Trend []TrendPoint `json:",omitempty" xml:"Trend>Point,omitempty"`
+}
+
+// PrintJSON writes the report as an indented JSON document.
+func PrintJSON(report Report) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// PrintXML writes the report as an indented XML document, with a <ChannelCountReport> root element.
+func PrintXML(report Report) error {
+	document := struct {
+		XMLName xml.Name `xml:"ChannelCountReport"`
+		Report
+	}{Report: report}
+
+	if _, err := fmt.Fprint(os.Stdout, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(os.Stdout)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(os.Stdout)
+	return err
+}
+
+// ndjsonRecord is a single line of the NDJSON output format, describing one of a user's teams.
+type ndjsonRecord struct {
+	Username string
+	UserID   string
+	Team
+}
+
+// PrintNDJSONRecord writes the counts for one of a user's teams as a single line of JSON.
+func PrintNDJSONRecord(user User, team Team) error {
+	return json.NewEncoder(os.Stdout).Encode(ndjsonRecord{Username: user.Username, UserID: user.ID, Team: team})
+}
+
+// PrintUserInfo prints just the details of the resolved user, either as text or as a JSON document.
+func PrintUserInfo(user User, asJSON bool) error {
+	user.Teams = nil
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(user)
+	}
+
+	printUserDetails(user)
+	return nil
+}
+
+// PrintTeams prints the names and IDs of the user's teams, either as text or as a JSON document.
+func PrintTeams(user User, asJSON bool) error {
+	if asJSON {
+		type teamSummary struct {
+			Name string
+			ID   string
+		}
+		teams := []teamSummary{}
+		for _, team := range user.Teams {
+			teams = append(teams, teamSummary{Name: team.Name, ID: team.ID})
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(teams)
+	}
+
+	maxTeamNameLength := 0
+	for _, team := range user.Teams {
+		if len(team.Name) > maxTeamNameLength {
+			maxTeamNameLength = len(team.Name)
+		}
+	}
+
+	fmt.Printf("\nTeams for %s: %d\n\n", user.Username, len(user.Teams))
+	for _, team := range user.Teams {
+		fmt.Printf("%-*s : %s\n", maxTeamNameLength+2, team.Name, team.ID)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// PrintDMSummary prints just the user's direct and group message channel counts, either as text or as JSON.
+func PrintDMSummary(report Report, asJSON bool) error {
+	if asJSON {
+		return PrintJSON(report)
+	}
+
+	fmt.Println()
+	printUserDetails(report.User)
+	fmt.Printf("Direct Message Channels : %d\n", report.DMChannelCount)
+	fmt.Printf("Group Message Channels  : %d\n\n", report.GroupChannelCount)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startCPUProfile starts writing a pprof CPU profile to the named file.  The returned function stops the profile and
+// closes the file, and must be called before the program exits for the profile to be complete.
+func startCPUProfile(path string) (func(), error) {
+	DebugPrint("Writing CPU profile to " + path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		if err := file.Close(); err != nil {
+			LogMessage(warningLevel, "Failed to write the CPU profile: "+err.Error())
+		}
+	}, nil
+}
+
+// writeHeapProfile writes a pprof heap profile to the named file.  A garbage collection is run first, so that the
+// profile reflects the memory that is still in use.
+func writeHeapProfile(path string) error {
+	DebugPrint("Writing heap profile to " + path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// spinnerFrames are drawn in turn, overwriting each other, to show that the tool is still working
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+const spinnerInterval = 100 * time.Millisecond
+
+// progressEnabled controls whether a spinner is shown on stderr while waiting for Mattermost
+var progressEnabled bool = false
+
+// spinner draws a simple progress indicator on stderr until it is stopped.
+type spinner struct {
+	message string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// isTerminal reports whether the file is attached to a terminal, rather than a pipe or a regular file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// startSpinner starts drawing a spinner alongside the message.  If progress output is disabled, the returned spinner
+// does nothing, so callers don't need to check.
+func startSpinner(message string) *spinner {
+	s := &spinner{message: message}
+	if !progressEnabled {
+		return s
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+
+		for frame := 0; ; frame++ {
+			fmt.Fprintf(os.Stderr, "\r%s %s", spinnerFrames[frame%len(spinnerFrames)], s.message)
+			select {
+			case <-s.stop:
+				// Clear the line so that the spinner doesn't interfere with any subsequent output
+				fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", len(s.message)+2))
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return s
+}
+
+// Stop removes the spinner, waiting for it to be cleared from the terminal.
+func (s *spinner) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
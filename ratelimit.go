@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRateLimitWait is the longest that RateLimitAwareClient will wait for a rate limit to reset.  Anything longer is
+// left to the backoff in callWithRetry.
+const maxRateLimitWait = time.Minute
+
+// RateLimitAwareClient wraps the HTTP transport used by the Mattermost client.  When Mattermost rejects a request
+// with HTTP 429, it reports how many seconds remain until the rate limit resets in the X-Ratelimit-Reset header;
+// RateLimitAwareClient waits for that long and then repeats the request, so that rate limiting is handled without
+// using up the attempts allowed by callWithRetry.  If the repeated request is also rate limited, or the header is
+// missing, the response is returned as-is and callWithRetry's backoff takes over.
+type RateLimitAwareClient struct {
+	Transport http.RoundTripper
+}
+
+// RoundTrip makes the request, waiting out and repeating it once if it's rate limited.
+func (c *RateLimitAwareClient) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	response, err := transport.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusTooManyRequests {
+		return response, err
+	}
+
+	wait, ok := rateLimitReset(response.Header)
+	if !ok {
+		return response, nil
+	}
+
+	// The request can only be repeated if its body, if any, can be read again
+	retry := request
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return response, nil
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			return response, nil
+		}
+		retry = request.Clone(request.Context())
+		retry.Body = body
+	}
+
+	DebugPrint("Rate limited by Mattermost, retrying in " + wait.String())
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	select {
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	case <-time.After(wait):
+	}
+
+	return transport.RoundTrip(retry)
+}
+
+// rateLimitReset returns the time until the rate limit resets, from the X-Ratelimit-Reset header of a rate limited
+// response.  The boolean result is false if the header is missing or invalid, or the wait would be too long.
+func rateLimitReset(header http.Header) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(header.Get("X-Ratelimit-Reset"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxRateLimitWait {
+		return 0, false
+	}
+	return wait, true
+}
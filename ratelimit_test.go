@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimitReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "missing", value: "", wantOK: false},
+		{name: "not a number", value: "soon", wantOK: false},
+		{name: "negative", value: "-1", wantOK: false},
+		{name: "zero", value: "0", want: 0, wantOK: true},
+		{name: "within the limit", value: "5", want: 5 * time.Second, wantOK: true},
+		{name: "at the limit", value: "60", want: maxRateLimitWait, wantOK: true},
+		{name: "beyond the limit", value: "61", wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.value != "" {
+				header.Set("X-Ratelimit-Reset", test.value)
+			}
+			got, ok := rateLimitReset(header)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("rateLimitReset(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
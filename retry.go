@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const defaultRetryDelay = 500 * time.Millisecond
+
+// retryDelay is the backoff before the first retry of a failed API call, doubling on each subsequent attempt
+var retryDelay = defaultRetryDelay
+
+// requestCounter is used to give each API call a unique ID, so that the log lines for concurrent calls can be
+// correlated
+var requestCounter atomic.Uint64
+
+// nextRequestID returns a new ID for an API call.
+func nextRequestID() string {
+	return fmt.Sprintf("req-%d", requestCounter.Add(1))
+}
+
+// isRetryable reports whether a failed API call may succeed if repeated.  Server errors, rate limiting and failures
+// where no response was received at all are treated as transient; any other HTTP status is not.
+func isRetryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// callWithRetry makes a Mattermost API call, checking the response with checkResponse.  Transient failures are
+// retried, up to maxErrors attempts in total, with an exponential backoff starting at retryDelay.  Each call is
+// given a request ID, which is included in its debug messages and in any error returned.
+func callWithRetry[T any](ctx context.Context, function string, url string, call func() (T, *model.Response, error)) (T, error) {
+	requestID := nextRequestID()
+	delay := retryDelay
+
+	for attempt := 1; ; attempt++ {
+		DebugPrint(fmt.Sprintf("[%s] Calling %s (attempt %d)", requestID, function, attempt))
+		result, response, err := call()
+		err = checkResponse(function, url, response, err)
+		if err == nil {
+			DebugPrint(fmt.Sprintf("[%s] %s succeeded", requestID, function))
+			return result, nil
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			apiErr.RequestID = requestID
+		}
+		if attempt >= maxErrors || ctx.Err() != nil || !isRetryable(err) {
+			return result, err
+		}
+
+		DebugPrint(fmt.Sprintf("[%s] Attempt %d of %s failed, retrying in %s: %v", requestID, attempt, function, delay, err))
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// GetAllTeams pages through every team on the instance.
+func GetAllTeams(ctx context.Context, mmClient model.Client4) ([]Team, error) {
+	DebugPrint("Getting all teams")
+
+	etag := ""
+	var teamsList []Team
+
+	for page := 0; ; page++ {
+		teams, err := callWithRetry(ctx, "GetAllTeams", mmClient.URL, func() ([]*model.Team, *model.Response, error) {
+			return mmClient.GetAllTeams(ctx, etag, page, pageSize)
+		})
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve teams: "+err.Error())
+			return nil, err
+		}
+
+		for _, mmTeam := range teams {
+			teamsList = append(teamsList, Team{
+				Name: mmTeam.DisplayName,
+				ID:   mmTeam.Id,
+			})
+		}
+
+		if len(teams) < pageSize {
+			break
+		}
+	}
+
+	return teamsList, nil
+}
+
+// channelPageFunc matches the signatures of the paginated public and private channel API calls.
+type channelPageFunc func(ctx context.Context, teamID string, page int, perPage int, etag string) ([]*model.Channel, *model.Response, error)
+
+// countChannelPages counts the channels returned by a paginated channel API call.
+func countChannelPages(ctx context.Context, getPage channelPageFunc, functionName string, serverURL string, teamID string) (int, error) {
+	etag := ""
+	count := 0
+
+	for page := 0; ; page++ {
+		channels, err := callWithRetry(ctx, functionName, serverURL, func() ([]*model.Channel, *model.Response, error) {
+			return getPage(ctx, teamID, page, pageSize, etag)
+		})
+		if err != nil {
+			// A refusal is handled by the caller, which may be able to carry on without these channels
+			if !isForbidden(err) {
+				LogMessage(errorLevel, "Failed to retrieve channels: "+err.Error())
+			}
+			return -1, err
+		}
+
+		count += len(channels)
+		if len(channels) < pageSize {
+			break
+		}
+	}
+
+	return count, nil
+}
+
+// GetTeamChannelCount counts all of the public and private channels in a team, regardless of membership.  Listing
+// a team's private channels requires a sysadmin token, so if that's refused only the public channels are counted.
+func GetTeamChannelCount(ctx context.Context, mmClient model.Client4, teamID string) (int, error) {
+	DebugPrint("Getting total channel count for team ID: " + teamID)
+
+	publicCount, err := countChannelPages(ctx, mmClient.GetPublicChannelsForTeam, "GetPublicChannelsForTeam", mmClient.URL, teamID)
+	if err != nil {
+		return -1, err
+	}
+	privateCount, err := countChannelPages(ctx, mmClient.GetPrivateChannelsForTeam, "GetPrivateChannelsForTeam", mmClient.URL, teamID)
+	if isForbidden(err) {
+		LogMessage(warningLevel, "Not permitted to list the private channels for team ID "+teamID+" - counting public channels only")
+		return publicCount, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return publicCount + privateCount, nil
+}
+
+// ProcessAllTeams counts the channels in every team on the instance.  Teams that can't be counted are logged and
+// reported with a count of -1.
+func ProcessAllTeams(ctx context.Context, mmClient model.Client4, exclusions []string) ([]Team, error) {
+	teams, err := GetAllTeams(ctx, mmClient)
+	if err != nil {
+		return nil, err
+	}
+	teams = filterExcludedTeams(teams, exclusions)
+
+	for i := range teams {
+		teams[i].ChannelCount, err = GetTeamChannelCount(ctx, mmClient, teams[i].ID)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to get channel count for team "+teams[i].Name)
+		}
+	}
+
+	return teams, nil
+}
+
+// PrintTeamsSummary writes the instance-wide channel count for each team in the requested format.
+func PrintTeamsSummary(teams []Team, format string, options summaryOptions) error {
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(teams)
+	}
+	if format == formatNDJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, team := range teams {
+			if err := encoder.Encode(team); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	header := []string{"Team", "TeamID", "ChannelCount"}
+	var rows [][]string
+	for _, team := range teams {
+		rows = append(rows, []string{team.Name, team.ID, strconv.Itoa(team.ChannelCount)})
+	}
+
+	switch format {
+	case formatCSV:
+		writer := csv.NewWriter(os.Stdout)
+		if !options.noHeader {
+			if err := writer.Write(header); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case formatTSV:
+		if !options.noHeader {
+			fmt.Println(strings.Join(header, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Println(strings.Join(row, "\t"))
+		}
+		return nil
+	}
+
+	maxTeamNameLength := 0
+	totalChannelCount := 0
+	for _, team := range teams {
+		if len(team.Name) > maxTeamNameLength {
+			maxTeamNameLength = len(team.Name)
+		}
+		if team.ChannelCount > 0 {
+			totalChannelCount += team.ChannelCount
+		}
+	}
+	maxTeamNameLength += 2
+
+	if !options.noHeader {
+		fmt.Printf("\n\nAll Teams\n")
+		fmt.Printf("=========\n\n")
+	}
+	for _, team := range teams {
+		fmt.Printf("%-*s : %d\n", maxTeamNameLength, team.Name, team.ChannelCount)
+	}
+	fmt.Printf("\nTeams on instance   : %d\n", len(teams))
+	fmt.Printf("Total channel count : %d\n\n", totalChannelCount)
+
+	return nil
+}
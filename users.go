@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// GetAllUsers pages through every user on the instance, returning those whose accounts are active.  This requires a
+// sysadmin token.
+func GetAllUsers(ctx context.Context, mmClient model.Client4) ([]User, error) {
+	DebugPrint("Getting all users")
+
+	etag := ""
+	var users []User
+
+	for page := 0; ; page++ {
+		mmUsers, err := callWithRetry(ctx, "GetUsers", mmClient.URL, func() ([]*model.User, *model.Response, error) {
+			return mmClient.GetUsers(ctx, page, pageSize, etag)
+		})
+		if err != nil {
+			LogMessage(errorLevel, "Failed to retrieve users: "+err.Error())
+			return nil, err
+		}
+
+		for _, mmUser := range mmUsers {
+			if mmUser.DeleteAt != 0 {
+				DebugPrint("Skipping deactivated user: " + mmUser.Username)
+				continue
+			}
+			users = append(users, *newUserFromModel(mmUser, "all users"))
+		}
+
+		if len(mmUsers) < pageSize {
+			break
+		}
+	}
+
+	return users, nil
+}
+
+// ReadUsernames reads a list of usernames, one per line, skipping any blank lines.
+func ReadUsernames(reader io.Reader) ([]string, error) {
+	var usernames []string
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		username := strings.TrimSpace(scanner.Text())
+		if username == "" {
+			DebugPrint(fmt.Sprintf("Skipping empty line %d", line))
+			continue
+		}
+		usernames = append(usernames, username)
+	}
+
+	return usernames, scanner.Err()
+}
+
+// GetUsersByUsername looks up each of the usernames in turn.  Users that can't be found are logged and skipped.
+func GetUsersByUsername(ctx context.Context, mmClient model.Client4, usernames []string) []User {
+	var users []User
+
+	for _, username := range usernames {
+		if ctx.Err() != nil {
+			break
+		}
+		user, err := GetUserIDFromUsername(ctx, mmClient, username)
+		if err != nil {
+			LogMessage(warningLevel, "Skipping user "+username+", who could not be retrieved")
+			continue
+		}
+		users = append(users, *user)
+	}
+
+	return users
+}
+
+// ProcessAllUsers counts the channels for every active user on the instance.
+func ProcessAllUsers(ctx context.Context, mmClient model.Client4, exclusions []string, options countOptions, concurrency int) ([]Report, error) {
+	users, err := GetAllUsers(ctx, mmClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessUsers(ctx, mmClient, users, exclusions, options, concurrency)
+}
+
+// ProcessUsers counts the channels for each of the users in turn.  Failures for individual users are logged and
+// skipped, so that one problem account doesn't prevent the rest of the audit.
+func ProcessUsers(ctx context.Context, mmClient model.Client4, users []User, exclusions []string, options countOptions, concurrency int) ([]Report, error) {
+	var reports []Report
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return reports, ctx.Err()
+		}
+
+		teams, err := GetTeamsForUser(ctx, mmClient, user.ID)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to retrieve teams for user "+user.Username)
+			continue
+		}
+		user.Teams = filterExcludedTeams(teams, exclusions)
+
+		teamErrors := CountChannelsForTeams(ctx, mmClient, user.Teams, user, options, concurrency)
+		if len(teamErrors) > 0 {
+			LogMessage(warningLevel, fmt.Sprintf("Failed to get channel counts for %d teams for user %s", len(teamErrors), user.Username))
+		}
+
+		var totalDMChannels, totalGroupChannels int
+		var dmError string
+		if !options.noDMs {
+			dmChannels, err := GetDirectMessageChannels(ctx, mmClient, user.ID)
+			if err != nil {
+				LogMessage(warningLevel, "Failed to count direct message channels for user "+user.Username)
+				dmError = err.Error()
+			}
+			totalDMChannels, totalGroupChannels = CountDirectMessageChannels(selectDirectMessageChannels(dmChannels, options))
+		}
+
+		user.TotalChannelCount = sumChannelCounts(user.Teams, totalDMChannels)
+
+		reports = append(reports, Report{
+			User:              user,
+			DMChannelCount:    totalDMChannels,
+			GroupChannelCount: totalGroupChannels,
+			DMError:           dmError,
+		})
+	}
+
+	return reports, nil
+}
+
+// ListUsersWithHighChannelCount counts the channels for every active user on the instance, returning those whose
+// total channel count exceeds the threshold.  This requires a sysadmin token.
+func ListUsersWithHighChannelCount(ctx context.Context, mmClient model.Client4, threshold int) ([]User, error) {
+	reports, err := ProcessAllUsers(ctx, mmClient, nil, countOptions{}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	for _, report := range reports {
+		if report.TotalChannelCount > threshold {
+			users = append(users, report.User)
+		}
+	}
+
+	return users, nil
+}
+
+// sumChannelCounts returns the channel count across all of the teams, plus the user's direct message channels.
+func sumChannelCounts(teams []Team, dmChannelCount int) int {
+	total := dmChannelCount
+	for _, team := range teams {
+		// Teams that couldn't be counted have a count of -1
+		if team.ChannelCount > 0 {
+			total += team.ChannelCount
+		}
+	}
+	return total
+}
+
+// PrintUsersSummary writes one summary row per user in the requested format, followed by a grand total in text mode.
+func PrintUsersSummary(reports []Report, format string, options summaryOptions) error {
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	// Each team has already been written as it was counted
+	if format == formatNDJSON {
+		return nil
+	}
+
+	header := []string{"Username", "Email", "TeamCount", "ChannelCount", "DMChannelCount", "GroupChannelCount", "TotalChannelCount"}
+	var rows [][]string
+	for _, report := range reports {
+		rows = append(rows, []string{
+			report.Username,
+			report.Email,
+			strconv.Itoa(len(report.Teams)),
+			strconv.Itoa(report.TotalChannelCount - report.DMChannelCount),
+			strconv.Itoa(report.DMChannelCount),
+			strconv.Itoa(report.GroupChannelCount),
+			strconv.Itoa(report.TotalChannelCount),
+		})
+	}
+
+	switch format {
+	case formatCSV:
+		writer := csv.NewWriter(os.Stdout)
+		if !options.noHeader {
+			if err := writer.Write(header); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case formatTSV:
+		if !options.noHeader {
+			fmt.Println(strings.Join(header, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Println(strings.Join(row, "\t"))
+		}
+		return nil
+	}
+
+	maxUsernameLength := 0
+	grandTotal := 0
+	for _, report := range reports {
+		if len(report.Username) > maxUsernameLength {
+			maxUsernameLength = len(report.Username)
+		}
+		grandTotal += report.TotalChannelCount
+	}
+	maxUsernameLength += 2
+
+	if !options.noHeader {
+		fmt.Printf("\n\nUsers\n")
+		fmt.Printf("=====\n\n")
+	}
+	for _, report := range reports {
+		fmt.Printf("%-*s : %d\n", maxUsernameLength, report.Username, report.TotalChannelCount)
+	}
+	fmt.Printf("\nUsers processed     : %d\n", len(reports))
+	fmt.Printf("Grand total channels: %d\n\n", grandTotal)
+
+	return nil
+}
+
+// heavyUser is the summary of a user reported by -find-heavy-users.
+type heavyUser struct {
+	Username          string
+	Email             string
+	TotalChannelCount int
+}
+
+// PrintHeavyUsers writes the username, email and total channel count of each user in the requested format, with the
+// users in the most channels first.
+func PrintHeavyUsers(users []User, threshold int, format string, options summaryOptions) error {
+	heavyUsers := make([]heavyUser, 0, len(users))
+	for _, user := range users {
+		heavyUsers = append(heavyUsers, heavyUser{Username: user.Username, Email: user.Email, TotalChannelCount: user.TotalChannelCount})
+	}
+	sort.SliceStable(heavyUsers, func(i, j int) bool {
+		return heavyUsers[i].TotalChannelCount > heavyUsers[j].TotalChannelCount
+	})
+
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(heavyUsers)
+	case formatNDJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		for _, user := range heavyUsers {
+			if err := encoder.Encode(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	header := []string{"Username", "Email", "TotalChannelCount"}
+	var rows [][]string
+	for _, user := range heavyUsers {
+		rows = append(rows, []string{user.Username, user.Email, strconv.Itoa(user.TotalChannelCount)})
+	}
+
+	switch format {
+	case formatCSV:
+		writer := csv.NewWriter(os.Stdout)
+		if !options.noHeader {
+			if err := writer.Write(header); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case formatTSV:
+		if !options.noHeader {
+			fmt.Println(strings.Join(header, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Println(strings.Join(row, "\t"))
+		}
+		return nil
+	}
+
+	maxUsernameLength, maxEmailLength := 0, 0
+	for _, user := range heavyUsers {
+		if len(user.Username) > maxUsernameLength {
+			maxUsernameLength = len(user.Username)
+		}
+		if len(user.Email) > maxEmailLength {
+			maxEmailLength = len(user.Email)
+		}
+	}
+	maxUsernameLength += 2
+	maxEmailLength += 2
+
+	if !options.noHeader {
+		title := fmt.Sprintf("Users in more than %d channels", threshold)
+		fmt.Printf("\n\n%s\n", title)
+		fmt.Printf("%s\n\n", strings.Repeat("=", len(title)))
+	}
+	for _, user := range heavyUsers {
+		fmt.Printf("%-*s %-*s : %d\n", maxUsernameLength, user.Username, maxEmailLength, user.Email, user.TotalChannelCount)
+	}
+	fmt.Printf("\nUsers found : %d\n\n", len(heavyUsers))
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestSumChannelCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		teams []Team
+		dms   int
+		want  int
+	}{
+		{name: "no teams", dms: 4, want: 4},
+		{name: "teams and direct messages", teams: []Team{{ChannelCount: 5}, {ChannelCount: 3}}, dms: 2, want: 10},
+		{name: "failed teams are skipped", teams: []Team{{ChannelCount: 5}, {ChannelCount: -1}}, dms: 1, want: 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sumChannelCounts(test.teams, test.dms); got != test.want {
+				t.Errorf("sumChannelCounts() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
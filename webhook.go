@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// webhookPayload follows the Mattermost incoming webhook schema, which Slack also accepts.
+type webhookPayload struct {
+	Text string `json:"text"`
+}
+
+// alertPayload extends the webhook payload with the details of a threshold breach, so that alerts can be routed
+// according to their severity.
+type alertPayload struct {
+	Text         string `json:"text"`
+	Severity     string `json:"severity"`
+	Threshold    int    `json:"threshold"`
+	ChannelCount int    `json:"channel_count"`
+}
+
+// checkThresholds compares a channel count against the warning and error thresholds, returning the severity and
+// threshold of the most serious breach.  An empty severity means that neither threshold was exceeded.  Thresholds of
+// zero or less are disabled.
+func checkThresholds(channelCount int, thresholdWarn int, thresholdError int) (LogLevel, int) {
+	if thresholdError > 0 && channelCount > thresholdError {
+		return errorLevel, thresholdError
+	}
+	if thresholdWarn > 0 && channelCount > thresholdWarn {
+		return warningLevel, thresholdWarn
+	}
+	return "", 0
+}
+
+// BuildMarkdownSummary formats the report as a Markdown table, suitable for posting to a chat channel.
+func BuildMarkdownSummary(report Report) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "#### Channel count for @%s\n\n", report.Username)
+	builder.WriteString("| Team | Channels |\n")
+	builder.WriteString("|:-----|---------:|\n")
+	for _, team := range report.Teams {
+		fmt.Fprintf(&builder, "| %s | %d |\n", team.Name, team.ChannelCount)
+	}
+	fmt.Fprintf(&builder, "\n**Direct Message Channels:** %d\n", report.DMChannelCount)
+	fmt.Fprintf(&builder, "**Group Message Channels:** %d\n", report.GroupChannelCount)
+	fmt.Fprintf(&builder, "**Total channel count:** %d\n", report.TotalChannelCount)
+
+	return builder.String()
+}
+
+// PostWebhook sends the payload, encoded as JSON, to an incoming webhook URL.
+func PostWebhook(ctx context.Context, url string, payload any) error {
+	DebugPrint("Posting to webhook: " + url)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP status %d", response.StatusCode)
+	}
+
+	return nil
+}
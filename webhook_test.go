@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestCheckThresholds(t *testing.T) {
+	tests := []struct {
+		name          string
+		channelCount  int
+		warn, err     int
+		wantLevel     LogLevel
+		wantThreshold int
+	}{
+		{name: "no thresholds", channelCount: 500},
+		{name: "below both", channelCount: 50, warn: 100, err: 200},
+		{name: "at the warning threshold", channelCount: 100, warn: 100, err: 200},
+		{name: "above the warning threshold", channelCount: 101, warn: 100, err: 200, wantLevel: warningLevel, wantThreshold: 100},
+		{name: "above the error threshold", channelCount: 201, warn: 100, err: 200, wantLevel: errorLevel, wantThreshold: 200},
+		{name: "error threshold only", channelCount: 150, err: 100, wantLevel: errorLevel, wantThreshold: 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			level, threshold := checkThresholds(test.channelCount, test.warn, test.err)
+			if level != test.wantLevel || threshold != test.wantThreshold {
+				t.Errorf("checkThresholds(%d, %d, %d) = %q, %d, want %q, %d", test.channelCount, test.warn, test.err,
+					level, threshold, test.wantLevel, test.wantThreshold)
+			}
+		})
+	}
+}